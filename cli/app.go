@@ -1,13 +1,10 @@
 package cli
 
 import (
-	"bytes"
-	"io/ioutil"
-	"os"
-	"os/exec"
+	"errors"
+	"fmt"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/txgruppi/run/build"
 	"github.com/txgruppi/run/logger"
 	"github.com/txgruppi/run/text"
@@ -18,7 +15,8 @@ import (
 const (
 	description = "Compile config templates based on environment variables" +
 		" and run a command after the template is successfully compiled." +
-		"\n   The compiled file will have the permissions set to 0777." +
+		"\n   The compiled files are written atomically with the permissions set" +
+		" by --output-mode, 0600 by default." +
 		"\n   Check the projects page for the documentation and more info at" +
 		" https://github.com/txgruppi/run"
 )
@@ -32,13 +30,18 @@ func NewApp() *cli.App {
 	app.Name = "run"
 	app.Usage = "Docker container command runner"
 	app.Description = description
-	app.UsageText = app.Name + ` -i <file> -o <file> [command[ args]]`
+	app.UsageText = app.Name + ` -i <file> -o <file> [-t <file>:<file>] [command[ args]]`
 	app.Authors = []cli.Author{
 		{
 			Name:  "Tarcisio Gruppi",
 			Email: "txgruppi@gmail.com",
 		},
 	}
+	app.OnUsageError = func(c *cli.Context, err error, isSubcommand bool) error {
+		fmt.Fprintf(c.App.Writer, "Incorrect Usage. %s\n\n", err)
+		cli.ShowAppHelp(c)
+		return cli.NewExitError("", exitInvalidOption)
+	}
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
 			Name:   "debug",
@@ -55,30 +58,147 @@ func NewApp() *cli.App {
 			Usage:  "The output path for the compiled config file",
 			EnvVar: "RUN_OUTPUT",
 		},
+		cli.StringFlag{
+			Name:   "output-mode",
+			Usage:  "The permissions of the compiled files, in octal",
+			Value:  "0600",
+			EnvVar: "RUN_OUTPUT_MODE",
+		},
+		cli.StringFlag{
+			Name:   "output-owner",
+			Usage:  "The user:group owning the compiled files, names or numeric ids",
+			EnvVar: "RUN_OUTPUT_OWNER",
+		},
+		cli.StringSliceFlag{
+			Name:   "template, t",
+			Usage:  "A template to be rendered as an input:output pair, can be repeated",
+			EnvVar: "RUN_TEMPLATE",
+		},
+		cli.StringSliceFlag{
+			Name:   "template-dir",
+			Usage:  "An input:output pair of directories, every *.dist and *.tmpl file in input is rendered to the same path in output without the extension, can be repeated",
+			EnvVar: "RUN_TEMPLATE_DIR",
+		},
 		cli.IntFlag{
 			Name:   "delay, d",
 			Usage:  "Number of seconds to wait before running the command",
 			EnvVar: "RUN_DELAY",
 		},
+		cli.StringSliceFlag{
+			Name:  "json, j",
+			Usage: sourceUsage("json", "JSON data to be used by JSONLoader, can be repeated"),
+		},
+		cli.StringSliceFlag{
+			Name:  "remote-json, r",
+			Usage: sourceUsage("remote-json", "URL to a JSON file to be used by RemoteJSONLoader, can be repeated"),
+		},
+		cli.StringSliceFlag{
+			Name:  "json-file, f",
+			Usage: sourceUsage("json-file", "Path to a JSON file to be used by JSONFileLoader, can be repeated"),
+		},
+		cli.StringSliceFlag{
+			Name:  "aws-secret",
+			Usage: sourceUsage("aws-secret", "The ARN or name of a secret with a JSON encoded value, can be repeated"),
+		},
+		cli.StringSliceFlag{
+			Name:  "yaml",
+			Usage: sourceUsage("yaml", "YAML data to be used by YAMLLoader, can be repeated"),
+		},
+		cli.StringSliceFlag{
+			Name:  "remote-yaml",
+			Usage: sourceUsage("remote-yaml", "URL to a YAML file to be used by RemoteYAMLLoader, can be repeated"),
+		},
+		cli.StringSliceFlag{
+			Name:  "yaml-file",
+			Usage: sourceUsage("yaml-file", "Path to a YAML file to be used by YAMLFileLoader, can be repeated"),
+		},
+		cli.StringSliceFlag{
+			Name:  "toml",
+			Usage: sourceUsage("toml", "TOML data to be used by TOMLLoader, can be repeated"),
+		},
+		cli.StringSliceFlag{
+			Name:  "toml-file",
+			Usage: sourceUsage("toml-file", "Path to a TOML file to be used by TOMLFileLoader, can be repeated"),
+		},
+		cli.StringSliceFlag{
+			Name:  "values-env-file",
+			Usage: sourceUsage("values-env-file", "Path to a dotenv file to be used by DotenvFileLoader, its variables are not added to the environment, can be repeated"),
+		},
+		cli.BoolFlag{
+			Name:   "values-env-expand",
+			Usage:  "Expand the ${NAME} references in the --values-env-file values",
+			EnvVar: "RUN_VALUES_ENV_EXPAND",
+		},
+		cli.StringSliceFlag{
+			Name:  "ini-file",
+			Usage: sourceUsage("ini-file", "Path to an INI file to be used by INIFileLoader, keys are section.key, can be repeated"),
+		},
+		cli.StringSliceFlag{
+			Name:  "properties-file",
+			Usage: sourceUsage("properties-file", "Path to a Java properties file to be used by PropertiesFileLoader, can be repeated"),
+		},
+		cli.StringSliceFlag{
+			Name:  "secrets-dir",
+			Usage: sourceUsage("secrets-dir", "A directory with one file per value, like /run/secrets, to be used by DirectoryLoader, can be repeated"),
+		},
+		cli.BoolFlag{
+			Name:   "secrets-keep-newline",
+			Usage:  "Keep the trailing newline of the --secrets-dir values",
+			EnvVar: "RUN_SECRETS_KEEP_NEWLINE",
+		},
+		cli.StringSliceFlag{
+			Name:   "source",
+			Usage:  "A data source as kind:value, like json-file:base.json, or env, in order of precedence, can be repeated",
+			EnvVar: "RUN_SOURCE",
+		},
+		cli.DurationFlag{
+			Name:   "remote-connect-timeout",
+			Usage:  "The timeout to connect to a remote data source, 0 for no timeout",
+			Value:  valuesloader.DefaultRemoteOptions.ConnectTimeout,
+			EnvVar: "RUN_REMOTE_CONNECT_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   "remote-read-timeout",
+			Usage:  "The timeout to read the response of a remote data source, 0 for no timeout",
+			Value:  valuesloader.DefaultRemoteOptions.ReadTimeout,
+			EnvVar: "RUN_REMOTE_READ_TIMEOUT",
+		},
+		cli.IntFlag{
+			Name:   "remote-retries",
+			Usage:  "How many times a failed request to a remote data source is retried",
+			Value:  valuesloader.DefaultRemoteOptions.Retries,
+			EnvVar: "RUN_REMOTE_RETRIES",
+		},
+		cli.DurationFlag{
+			Name:   "remote-backoff",
+			Usage:  "The delay before retrying a remote data source, doubled after every retry",
+			Value:  valuesloader.DefaultRemoteOptions.Backoff,
+			EnvVar: "RUN_REMOTE_BACKOFF",
+		},
+		cli.StringSliceFlag{
+			Name:   "remote-json-header",
+			Usage:  "A \"Name: value\" header sent with the --remote-json and --remote-yaml requests, can be repeated",
+			EnvVar: "RUN_REMOTE_JSON_HEADER",
+		},
 		cli.StringFlag{
-			Name:   "json, j",
-			Usage:  "JSON data to be used by JSONLoader",
-			EnvVar: "RUN_JSON",
+			Name:   "remote-ca-file",
+			Usage:  "A PEM file with additional certificate authorities trusted by the remote data sources",
+			EnvVar: "RUN_REMOTE_CA_FILE",
 		},
 		cli.StringFlag{
-			Name:   "remote-json, r",
-			Usage:  "URL to a JSON file to be used by RemoteJSONLoader",
-			EnvVar: "RUN_REMOTE_JSON",
+			Name:   "remote-cert-file",
+			Usage:  "A PEM file with the client certificate sent to the remote data sources",
+			EnvVar: "RUN_REMOTE_CERT_FILE",
 		},
 		cli.StringFlag{
-			Name:   "json-file, f",
-			Usage:  "Path to a JSON file to be used by JSONFileLoader",
-			EnvVar: "RUN_JSON_FILE",
+			Name:   "remote-key-file",
+			Usage:  "A PEM file with the key of --remote-cert-file",
+			EnvVar: "RUN_REMOTE_KEY_FILE",
 		},
 		cli.StringFlag{
-			Name:   "aws-secret",
-			Usage:  "The ARN or name of a secret with a JSON encoded value",
-			EnvVar: "RUN_AWS_SECRET_ARN",
+			Name:   "remote-proxy",
+			Usage:  "The URL of the proxy used by the remote data sources, by default it is read from HTTP_PROXY, HTTPS_PROXY and NO_PROXY",
+			EnvVar: "RUN_REMOTE_PROXY",
 		},
 		cli.StringFlag{
 			Name:   "env-file",
@@ -90,194 +210,205 @@ func NewApp() *cli.App {
 			Usage:  "Create a environment variable with the contents of the output file",
 			EnvVar: "RUN_ENV_OUTPUT_VAR",
 		},
+		cli.BoolFlag{
+			Name:   "exec",
+			Usage:  "Replace the run process by the command instead of running it as a child",
+			EnvVar: "RUN_EXEC",
+		},
+		cli.StringSliceFlag{
+			Name:   "wait-for",
+			Usage:  "A tcp://host:port, http(s)://url or file:path that must be ready before running the command, can be repeated",
+			EnvVar: "RUN_WAIT_FOR",
+		},
+		cli.DurationFlag{
+			Name:   "wait-timeout",
+			Usage:  "The timeout of every --wait-for check",
+			Value:  5 * time.Second,
+			EnvVar: "RUN_WAIT_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   "wait-interval",
+			Usage:  "How long to wait before checking a --wait-for dependency again",
+			Value:  time.Second,
+			EnvVar: "RUN_WAIT_INTERVAL",
+		},
+		cli.DurationFlag{
+			Name:   "wait-deadline",
+			Usage:  "How long to wait for all the --wait-for dependencies to be ready",
+			Value:  time.Minute,
+			EnvVar: "RUN_WAIT_DEADLINE",
+		},
+		cli.StringSliceFlag{
+			Name:   "pre-command",
+			Usage:  "A command line run before the command, it must succeed for the command to run, can be repeated",
+			EnvVar: "RUN_PRE_COMMAND",
+		},
+		cli.StringSliceFlag{
+			Name:   "sidecar",
+			Usage:  "A command line run next to the command with its output prefixed by its name, stopped when the command exits, can be repeated",
+			EnvVar: "RUN_SIDECAR",
+		},
+		cli.StringFlag{
+			Name:   "restart",
+			Usage:  "When to restart the command after it exits: never, on-failure or always",
+			Value:  "never",
+			EnvVar: "RUN_RESTART",
+		},
+		cli.IntFlag{
+			Name:   "max-restarts",
+			Usage:  "The maximum number of times the command is restarted, 0 for no limit",
+			EnvVar: "RUN_MAX_RESTARTS",
+		},
+		cli.DurationFlag{
+			Name:   "restart-delay",
+			Usage:  "The delay before the first restart, doubled after every restart up to 1m",
+			Value:  time.Second,
+			EnvVar: "RUN_RESTART_DELAY",
+		},
+		cli.StringFlag{
+			Name:   "delims",
+			Usage:  "The left and right token delimiters separated by a space",
+			Value:  "{{ }}",
+			EnvVar: "RUN_DELIMS",
+		},
+		cli.BoolFlag{
+			Name:   "watch",
+			Usage:  "Render the templates again every --watch-interval and reload the command when they change",
+			EnvVar: "RUN_WATCH",
+		},
+		cli.DurationFlag{
+			Name:   "watch-interval",
+			Usage:  "How often the sources are checked for changes in watch mode",
+			Value:  30 * time.Second,
+			EnvVar: "RUN_WATCH_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   "watch-reload",
+			Usage:  "The signal sent to the command when the templates change, or \"restart\" to restart it",
+			Value:  "SIGHUP",
+			EnvVar: "RUN_WATCH_RELOAD",
+		},
+		cli.BoolFlag{
+			Name:   "strict",
+			Usage:  "Fail before running the command if any token cannot be resolved",
+			EnvVar: "RUN_STRICT",
+		},
 	}
-	app.Action = func(c *cli.Context) (err error) {
-		var envData []byte
-		var envTokens []*text.Token
-		var inputRender, envRender []byte
-		var envSlice []string
-		var vl *valuesloader.ValuesLoader
-
+	app.Action = func(c *cli.Context) error {
 		logger.Debug = c.Bool("debug")
-		input := c.String("input")
-		output := c.String("output")
 		delay := c.Int("delay")
 
-		if delay > 0 {
-			logger.Printf("Starting delay of %s", time.Duration(delay)*time.Second)
-			time.Sleep(time.Duration(delay) * time.Second)
+		opts := &renderOptions{
+			templates:    []*template{},
+			envFile:      c.String("env-file"),
+			envOutputVar: c.String("env-output-var"),
+			strict:       c.Bool("strict"),
 		}
 
-		logger.Printf("Registering environment loader")
-		envLoader, err := valuesloader.EnvironmentLoader()
+		var err error
+		opts.delims, err = text.ParseDelims(c.String("delims"))
 		if err != nil {
-			return newExitError(err, 4)
+			return newExitError(err, exitInvalidOption)
 		}
-		loaderFuncs := []valuesloader.ValueLoaderFunc{envLoader}
 
-		if value := c.String("json"); value != "" {
-			logger.Printf("Registering JSON loader with value %s", c.String("json"))
-			loader, err := valuesloader.JSONLoader([]byte(value))
-			if err != nil {
-				return newExitError(err, 5)
-			}
-			loaderFuncs = append(loaderFuncs, loader)
+		opts.output, err = parseOutputOptions(c.String("output-mode"), c.String("output-owner"))
+		if err != nil {
+			return newExitError(err, exitInvalidOption)
 		}
 
-		if value := c.String("remote-json"); value != "" {
-			logger.Printf("Registering remote JSON loader with URL %s", c.String("remote-json"))
-			loader, err := valuesloader.RemoteJSONLoader(value)
-			if err != nil {
-				return newExitError(err, 6)
+		var watch *watchOptions
+		if c.Bool("watch") {
+			if c.Bool("exec") {
+				return newExitError(errors.New("--exec cannot be used with --watch"), exitInvalidOption)
 			}
-			loaderFuncs = append(loaderFuncs, loader)
-		}
-
-		if value := c.String("json-file"); value != "" {
-			logger.Printf("Registering JSON file loader with file %s", c.String("json-file"))
-			loader, err := valuesloader.JSONFileLoader(value)
+			watch, err = parseWatchOptions(c.Duration("watch-interval"), c.String("watch-reload"))
 			if err != nil {
-				return newExitError(err, 7)
+				return newExitError(err, exitInvalidOption)
 			}
-			loaderFuncs = append(loaderFuncs, loader)
 		}
 
-		if value := c.String("aws-secret"); value != "" {
-			logger.Printf("Registering AWS SecretManager loader with SecretID %s", c.String("aws-secret"))
-			loader, err := valuesloader.AWSSecretsManagerLoader(value)
-			if err != nil {
-				return newExitError(err, 8)
-			}
-			loaderFuncs = append(loaderFuncs, loader)
+		restart, err := parseRestartOptions(c.String("restart"), c.Int("max-restarts"), c.Duration("restart-delay"))
+		if err != nil {
+			return newExitError(err, exitInvalidOption)
+		}
+		if c.Bool("exec") && restart.policy != restartNever {
+			return newExitError(errors.New("--exec cannot be used with --restart"), exitInvalidOption)
 		}
 
-		logger.Printf("Creating ValuesLoader")
-		vl, err = valuesloader.New(loaderFuncs...)
+		wait, err := parseWaitOptions(c.StringSlice("wait-for"), c.Duration("wait-timeout"), c.Duration("wait-interval"), c.Duration("wait-deadline"))
 		if err != nil {
-			return newExitError(err, 2)
+			return newExitError(err, exitInvalidOption)
 		}
 
-		if input != "" {
-			logger.Printf("Reading input file")
-			inputData, err := ioutil.ReadFile(input)
-			if err != nil {
-				return newExitError(err, 1)
-			}
-
-			logger.Printf("Finding input tokens")
-			inputTokens := text.Tokens(inputData)
-
-			logger.Printf("Rendering input data")
-			inputRender, err = render(inputData, inputTokens, vl)
-			if err != nil {
-				newExitError(err, 10)
-			}
-
-			if output != "" {
-				logger.Printf("Writing output file")
-				err = ioutil.WriteFile(output, inputRender, 0777)
-				if err != nil {
-					return newExitError(err, 3)
-				}
-			}
+		preCommands, err := parseCommands(c.StringSlice("pre-command"))
+		if err != nil {
+			return newExitError(err, exitInvalidOption)
+		}
+		sidecars, err := parseCommands(c.StringSlice("sidecar"))
+		if err != nil {
+			return newExitError(err, exitInvalidOption)
+		}
+		if len(sidecars) > 0 && c.Bool("exec") {
+			return newExitError(errors.New("--exec cannot be used with --sidecar"), exitInvalidOption)
+		}
+		if len(sidecars) > 0 && len(c.Args()) == 0 {
+			return newExitError(errors.New("--sidecar requires a command"), exitInvalidOption)
 		}
 
-		if c.String("env-file") != "" {
-			logger.Printf("Reading env file %s", c.String("env-file"))
-			envData, err = ioutil.ReadFile(c.String("env-file"))
+		if input := c.String("input"); input != "" {
+			opts.templates = append(opts.templates, &template{input: input, output: c.String("output")})
+			opts.hasInput = true
+		}
+		for _, value := range c.StringSlice("template") {
+			t, err := parseTemplatePair(value)
 			if err != nil {
-				return newExitError(err, 9)
+				return newExitError(err, exitInvalidOption)
 			}
-
-			logger.Printf("Finding env file tokens")
-			envTokens = text.Tokens(envData)
-
-			logger.Printf("Rendering env file")
-			envRender, err = render(envData, envTokens, vl)
+			opts.templates = append(opts.templates, t)
+		}
+		templateDirs := []*template{}
+		for _, value := range c.StringSlice("template-dir") {
+			dirs, err := parseTemplatePair(value)
 			if err != nil {
-				newExitError(err, 11)
+				return newExitError(err, exitInvalidOption)
 			}
-
-			logger.Printf("Getting complete environment values")
-			envSlice, err = environ(envRender)
+			templateDirs = append(templateDirs, dirs)
+		}
+		for _, dirs := range templateDirs {
+			logger.Printf("Finding templates in %s", dirs.input)
+			found, err := findTemplates(dirs)
 			if err != nil {
-				return newExitError(err, 12)
+				return newExitError(err, exitTemplateRead)
 			}
+			opts.templates = append(opts.templates, found...)
 		}
 
-		if c.String("env-output-var") != "" && inputRender != nil {
-			logger.Printf("Creating output environment variable with value:")
-			logger.Printf(string(inputRender))
-			pair := c.String("env-output-var") + "=" + string(inputRender)
-			if envSlice == nil {
-				logger.Printf("Getting complete environment values")
-				envSlice, err = environ([]byte(pair))
-				if err != nil {
-					return newExitError(err, 12)
-				}
-			} else {
-				logger.Printf("Adding output environment variable")
-				envSlice = append(envSlice, pair)
-			}
+		if delay > 0 {
+			logger.Printf("Starting delay of %s", time.Duration(delay)*time.Second)
+			time.Sleep(time.Duration(delay) * time.Second)
 		}
 
-		if len(c.Args()) == 0 {
-			logger.Printf("No command to run. Done")
-			return nil
+		r, err := renderAll(c, opts)
+		if err != nil {
+			return err
 		}
-
-		name := c.Args()[0]
-		args := c.Args()[1:]
-
-		logger.Printf("Preparing command %s with args %v", name, args)
-		cmd := exec.Command(name, args...)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = app.Writer
-		cmd.Stderr = cli.ErrWriter
-		if envSlice != nil {
-			logger.Printf("Adding enviroment variables to the command")
-			cmd.Env = envSlice
+		if err := writeAll(opts, r); err != nil {
+			return err
 		}
 
-		logger.Printf("Running command")
-		return cmd.Run()
-	}
-
-	return app
-}
-
-func render(in []byte, tks []*text.Token, vl *valuesloader.ValuesLoader) ([]byte, error) {
-	out := make([]byte, len(in))
-	copy(out, in)
-
-TokensLoop:
-	for _, token := range tks {
-		for _, key := range token.Keys {
-			if value, ok := vl.Lookup(key); ok {
-				out = text.Replace(out, token.Raw, value)
-				continue TokensLoop
-			}
+		if err := waitForDependencies(wait); err != nil {
+			return err
 		}
-		out = text.Replace(out, token.Raw, "")
-	}
-	return out, nil
-}
-
-func environ(envData []byte) ([]string, error) {
-	r := bytes.NewReader(envData)
-	em, err := godotenv.Parse(r)
-	if err != nil {
-		return nil, err
-	}
 
-	out := os.Environ()
-	for k, v := range em {
-		out = append(out, k+"="+v)
+		return runCommand(c, opts, &commandOptions{
+			watch:       watch,
+			restart:     restart,
+			preCommands: preCommands,
+			sidecars:    sidecars,
+			stdout:      c.App.Writer,
+			stderr:      cli.ErrWriter,
+		}, r)
 	}
 
-	return out, nil
-}
-
-func newExitError(err error, code int) error {
-	return cli.NewExitError(err.Error(), code)
+	return app
 }
@@ -0,0 +1,411 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/txgruppi/run/logger"
+	"github.com/txgruppi/run/supervisor"
+	"github.com/urfave/cli"
+)
+
+// stopTimeout is how long a command has to exit after SIGTERM before it is
+// killed.
+const stopTimeout = 10 * time.Second
+
+// maxRestartDelay is the longest delay between restarts of a command. The
+// delay is reset when a command runs for longer than that.
+const maxRestartDelay = time.Minute
+
+// Restart policies of the command.
+const (
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+// restartOptions are the restart policy of the command. A max of 0 means the
+// command is restarted with no limit. The delay between restarts starts at
+// delay and doubles after every restart.
+type restartOptions struct {
+	policy string
+	max    int
+	delay  time.Duration
+}
+
+// parseRestartOptions parses the --restart, --max-restarts and
+// --restart-delay options.
+func parseRestartOptions(policy string, max int, delay time.Duration) (*restartOptions, error) {
+	switch policy {
+	case restartNever, restartOnFailure, restartAlways:
+	default:
+		return nil, fmt.Errorf("invalid restart policy %q, expected %s, %s or %s", policy, restartNever, restartOnFailure, restartAlways)
+	}
+	if max < 0 {
+		return nil, fmt.Errorf("invalid max restarts %d", max)
+	}
+	if delay < 0 {
+		return nil, fmt.Errorf("invalid restart delay %s", delay)
+	}
+	return &restartOptions{policy: policy, max: max, delay: delay}, nil
+}
+
+// restart reports whether a command that exited with status must be restarted
+// after being restarted the given number of times.
+func (o *restartOptions) restart(status supervisor.Status, restarts int) bool {
+	if o.max > 0 && restarts >= o.max {
+		return false
+	}
+	switch o.policy {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return status.ExitCode() != 0
+	}
+	return false
+}
+
+// watchOptions are the settings of the watch mode. A nil signal means the
+// command is restarted when the rendered files change.
+type watchOptions struct {
+	interval time.Duration
+	signal   os.Signal
+}
+
+// parseWatchOptions parses the --watch-interval and --watch-reload options.
+// reload is either a signal name or "restart".
+func parseWatchOptions(interval time.Duration, reload string) (*watchOptions, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid watch interval %s", interval)
+	}
+	opts := &watchOptions{interval: interval}
+	if reload == "restart" {
+		return opts, nil
+	}
+	sig, err := supervisor.ParseSignal(reload)
+	if err != nil {
+		return nil, err
+	}
+	opts.signal = sig
+	return opts, nil
+}
+
+// commandOptions are the settings used to run the commands. preCommands and
+// sidecars are command lines already split into words. stdout and stderr are
+// shared by every command.
+type commandOptions struct {
+	watch       *watchOptions
+	restart     *restartOptions
+	preCommands [][]string
+	sidecars    [][]string
+	stdout      io.Writer
+	stderr      io.Writer
+}
+
+// runCommand runs the pre-commands and then the command given in the
+// arguments along with the sidecars, all with the rendered environment. The
+// command is restarted as set by the restart options and its last exit status
+// is returned as an exit error. When watch is set the sources are watched
+// while the command runs, or forever if there is no command.
+func runCommand(c *cli.Context, opts *renderOptions, cmdOpts *commandOptions, r *rendered) error {
+	for _, args := range cmdOpts.preCommands {
+		if err := runPreCommand(cmdOpts, args, r.env); err != nil {
+			return err
+		}
+	}
+
+	if len(c.Args()) == 0 {
+		if cmdOpts.watch == nil {
+			logger.Printf("No command to run. Done")
+			return nil
+		}
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		_, _, err := watchSources(c, opts, cmdOpts, r, nil, stop)
+		return err
+	}
+
+	if c.Bool("exec") {
+		env := r.env
+		if env == nil {
+			env = os.Environ()
+		}
+		name, args := c.Args()[0], c.Args()[1:]
+		logger.Printf("Replacing the current process by command %s with args %v", name, args)
+		return newCommandError(supervisor.Exec(name, args, env))
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	if len(cmdOpts.sidecars) > 0 {
+		cmdOpts.stdout = newSyncWriter(cmdOpts.stdout)
+		cmdOpts.stderr = newSyncWriter(cmdOpts.stderr)
+	}
+	sidecars, err := startSidecars(cmdOpts, r.env)
+	if err != nil {
+		return err
+	}
+	defer sidecars.stop()
+
+	restart := cmdOpts.restart
+	restarts := 0
+	delay := restart.delay
+	for {
+		started := time.Now()
+		proc, err := startCommand(cmdOpts, c.Args(), r.env)
+		if err != nil {
+			return err
+		}
+
+		var status supervisor.Status
+		if cmdOpts.watch != nil {
+			status, r, err = watchSources(c, opts, cmdOpts, r, proc, stop)
+			if err != nil {
+				return err
+			}
+		} else {
+			status = proc.Wait()
+		}
+		logger.Printf("Command exited with code %d", status.ExitCode())
+
+		if !restart.restart(status, restarts) || len(stop) > 0 {
+			return exitStatus(status)
+		}
+
+		if time.Since(started) > maxRestartDelay {
+			delay = restart.delay
+		}
+		logger.Printf("Restarting the command in %s", delay)
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return exitStatus(status)
+		}
+		restarts++
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+
+		logger.Printf("Rendering the templates again")
+		next, err := renderAll(c, opts)
+		if err == nil {
+			err = writeAll(opts, next)
+		}
+		// A stop signal received while rendering also cancels the data sources,
+		// so it is checked before the error, which is not worth reporting then.
+		if len(stop) > 0 {
+			return exitStatus(status)
+		}
+		if err != nil {
+			fmt.Fprintf(cmdOpts.stderr, "restart: %v\n", err)
+			continue
+		}
+		r = next
+	}
+}
+
+// exitStatus returns an exit error with the exit code of a command or nil if
+// it succeeded.
+func exitStatus(status supervisor.Status) error {
+	if status.ExitCode() != 0 {
+		return cli.NewExitError("", status.ExitCode())
+	}
+	return nil
+}
+
+// newCommand returns a command for args with the standard input of the
+// current process and the output streams in cmdOpts. A nil env means the
+// command inherits the environment of the current process.
+func newCommand(cmdOpts *commandOptions, args []string, env []string) *exec.Cmd {
+	logger.Printf("Preparing command %s with args %v", args[0], args[1:])
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = cmdOpts.stdout
+	cmd.Stderr = cmdOpts.stderr
+	if env != nil {
+		logger.Printf("Adding enviroment variables to the command")
+		cmd.Env = env
+	}
+	return cmd
+}
+
+// startCommand starts the command in args.
+func startCommand(cmdOpts *commandOptions, args []string, env []string) (*supervisor.Process, error) {
+	logger.Printf("Running command")
+	proc, err := supervisor.Start(newCommand(cmdOpts, args, env))
+	if err != nil {
+		return nil, newCommandError(err)
+	}
+	return proc, nil
+}
+
+// runPreCommand runs the command in args and waits for it to exit. It returns
+// an exit error if the command cannot be started or does not succeed.
+func runPreCommand(cmdOpts *commandOptions, args []string, env []string) error {
+	logger.Printf("Running pre-command %v", args)
+	proc, err := supervisor.Start(newCommand(cmdOpts, args, env))
+	if err != nil {
+		return newExitError(fmt.Errorf("pre-command %q failed: %v", strings.Join(args, " "), err), exitPreCommand)
+	}
+	if status := proc.Wait(); status.ExitCode() != 0 {
+		return newExitError(fmt.Errorf("pre-command %q failed with exit code %d", strings.Join(args, " "), status.ExitCode()), exitPreCommand)
+	}
+	return nil
+}
+
+// sidecarGroup are the sidecars running next to the command.
+type sidecarGroup struct {
+	procs    []*supervisor.Process
+	stopping chan struct{}
+}
+
+// startSidecars starts a command for every sidecar in cmdOpts. Their output is
+// prefixed by the base name of the command between brackets and they get no
+// input. A sidecar exiting is only reported.
+func startSidecars(cmdOpts *commandOptions, env []string) (*sidecarGroup, error) {
+	g := &sidecarGroup{stopping: make(chan struct{})}
+	for _, args := range cmdOpts.sidecars {
+		name := filepath.Base(args[0])
+		cmd := newCommand(cmdOpts, args, env)
+		cmd.Stdin = nil
+		cmd.Stdout = newPrefixWriter(cmdOpts.stdout, "["+name+"] ")
+		cmd.Stderr = newPrefixWriter(cmdOpts.stderr, "["+name+"] ")
+
+		logger.Printf("Running sidecar %s", name)
+		proc, err := supervisor.Start(cmd)
+		if err != nil {
+			g.stop()
+			return nil, newCommandError(err)
+		}
+		g.procs = append(g.procs, proc)
+
+		go func() {
+			<-proc.Done()
+			select {
+			case <-g.stopping:
+			default:
+				fmt.Fprintf(cmdOpts.stderr, "sidecar %s exited with code %d\n", name, proc.Wait().ExitCode())
+			}
+		}()
+	}
+	return g, nil
+}
+
+// stop stops every sidecar with SIGTERM and waits for them to exit.
+func (g *sidecarGroup) stop() {
+	close(g.stopping)
+	var wg sync.WaitGroup
+	for _, proc := range g.procs {
+		wg.Add(1)
+		go func(proc *supervisor.Process) {
+			defer wg.Done()
+			proc.Stop(syscall.SIGTERM, stopTimeout)
+		}(proc)
+	}
+	wg.Wait()
+}
+
+// watchSources renders the templates again every interval until the command
+// exits and returns its exit status along with the last rendered result. When
+// the result differs from the previous one the output files are written again
+// and the command receives the reload signal, or it is restarted if the
+// environment changed or no signal is set. Errors are reported and the previous
+// result is kept. Once a signal is received in stop the command is not
+// restarted anymore and, when there is no command, watchSources returns.
+func watchSources(c *cli.Context, opts *renderOptions, cmdOpts *commandOptions, r *rendered, proc *supervisor.Process, stop chan os.Signal) (supervisor.Status, *rendered, error) {
+	watch := cmdOpts.watch
+	ticker := time.NewTicker(watch.interval)
+	defer ticker.Stop()
+
+	for {
+		// The signals are forwarded to the command, which is waited for, so
+		// stop is only selected when there is no command.
+		var done <-chan struct{}
+		var stopped <-chan os.Signal
+		if proc != nil {
+			done = proc.Done()
+		} else {
+			stopped = stop
+		}
+		select {
+		case <-done:
+			return proc.Wait(), r, nil
+		case <-stopped:
+			return supervisor.Status{}, r, nil
+		case <-ticker.C:
+		}
+
+		logger.Printf("Checking sources for changes")
+		next, err := renderAll(c, opts)
+		if len(stop) > 0 {
+			// The signal cancelled the data sources too, the command exits
+			// on its own as the signal was forwarded to it.
+			if proc == nil {
+				return supervisor.Status{}, r, nil
+			}
+			return proc.Wait(), r, nil
+		}
+		if err != nil {
+			fmt.Fprintf(cmdOpts.stderr, "watch: %v\n", err)
+			continue
+		}
+		outputs, env := next.changed(r)
+		if !outputs && !env {
+			continue
+		}
+		if err := writeAll(opts, next); err != nil {
+			fmt.Fprintf(cmdOpts.stderr, "watch: %v\n", err)
+			continue
+		}
+		r = next
+
+		if proc == nil {
+			continue
+		}
+		if watch.signal != nil && !env {
+			logger.Printf("Sources changed, sending %s to the command", watch.signal)
+			if err := proc.Signal(watch.signal); err != nil {
+				fmt.Fprintf(cmdOpts.stderr, "watch: %v\n", err)
+			}
+			continue
+		}
+
+		if len(stop) > 0 {
+			return proc.Wait(), r, nil
+		}
+		logger.Printf("Sources changed, restarting the command")
+		status := proc.Stop(syscall.SIGTERM, stopTimeout)
+		if len(stop) > 0 {
+			return status, r, nil
+		}
+		proc, err = startCommand(cmdOpts, c.Args(), r.env)
+		if err != nil {
+			return supervisor.Status{}, nil, err
+		}
+	}
+}
+
+func newExitError(err error, code int) error {
+	return cli.NewExitError(err.Error(), code)
+}
+
+// newCommandError returns an exit error for a command that could not be
+// executed, using the same exit codes as shells do.
+func newCommandError(err error) error {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		return newExitError(err, exitCommandNotFound)
+	}
+	return newExitError(err, exitCommandNotExecuted)
+}
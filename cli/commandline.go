@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// splitCommand splits a command line into words the way a shell does, without
+// any expansion. Words are separated by spaces and tabs, single quotes keep
+// everything up to the next single quote, and inside double quotes or outside
+// of quotes a backslash escapes the next character.
+func splitCommand(line string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(line); i++ {
+		b := line[i]
+		switch {
+		case b == ' ' || b == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case b == '\\':
+			if i+1 == len(line) {
+				return nil, fmt.Errorf("invalid command %q, trailing backslash", line)
+			}
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+
+		case b == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid command %q, unterminated quote", line)
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+
+		case b == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				word.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, fmt.Errorf("invalid command %q, unterminated quote", line)
+			}
+			inWord = true
+
+		default:
+			word.WriteByte(b)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	if len(words) == 0 {
+		return nil, errors.New("invalid command, the command line is empty")
+	}
+	return words, nil
+}
+
+// parseCommands splits every command line in values.
+func parseCommands(values []string) ([][]string, error) {
+	commands := [][]string{}
+	for _, value := range values {
+		words, err := splitCommand(value)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, words)
+	}
+	return commands, nil
+}
+
+// prefixWriter writes to w adding prefix at the start of every line.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	var buf bytes.Buffer
+	for rest := data; len(rest) > 0; {
+		if !p.midLine {
+			buf.Write(p.prefix)
+		}
+		i := bytes.IndexByte(rest, '\n')
+		if i == -1 {
+			buf.Write(rest)
+			p.midLine = true
+			break
+		}
+		buf.Write(rest[:i+1])
+		rest = rest[i+1:]
+		p.midLine = false
+	}
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// syncWriter serializes the writes to w, so it can be shared by the output
+// streams of several commands.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// newSyncWriter returns w itself if it is a file, as the commands write to it
+// directly, or a syncWriter for w otherwise.
+func newSyncWriter(w io.Writer) io.Writer {
+	if _, ok := w.(*os.File); ok {
+		return w
+	}
+	return &syncWriter{w: w}
+}
+
+func (s *syncWriter) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(data)
+}
@@ -0,0 +1,35 @@
+package cli
+
+// Exit codes used by run for its own failures. They are part of the public
+// interface and must not change. When the command runs, run exits with the
+// exit code of the command or 128 plus the signal number if the command is
+// killed by a signal.
+const (
+	exitTemplateRead         = 1
+	exitValuesLoader         = 2
+	exitOutputWrite          = 3
+	exitEnvironmentLoader    = 4
+	exitJSONLoader           = 5
+	exitRemoteJSONLoader     = 6
+	exitJSONFileLoader       = 7
+	exitAWSSecretsLoader     = 8
+	exitEnvFileRead          = 9
+	exitTemplateRender       = 10
+	exitEnvFileRender        = 11
+	exitEnviron              = 12
+	exitUnresolvedTokens     = 13
+	exitInvalidOption        = 14
+	exitPreCommand           = 15
+	exitWaitFor              = 16
+	exitYAMLLoader           = 17
+	exitRemoteYAMLLoader     = 18
+	exitYAMLFileLoader       = 19
+	exitTOMLLoader           = 20
+	exitTOMLFileLoader       = 21
+	exitDotenvFileLoader     = 22
+	exitINIFileLoader        = 23
+	exitPropertiesFileLoader = 24
+	exitDirectoryLoader      = 25
+	exitCommandNotExecuted   = 126
+	exitCommandNotFound      = 127
+)
@@ -0,0 +1,364 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/txgruppi/run/logger"
+	"github.com/txgruppi/run/valuesloader"
+	"github.com/urfave/cli"
+)
+
+// sourceKind is a kind of data source. load creates its loader from the value
+// given in the command line, which is empty for the kinds with no value.
+// Failures exit with exitCode. The values of the url kinds are logged with the
+// password redacted. envVar is read as a single value when the flag is not
+// given, as documents, URLs and paths may contain commas and the environment
+// variable of a slice flag would be split at them.
+type sourceKind struct {
+	name     string
+	hasValue bool
+	url      bool
+	envVar   string
+	exitCode int
+	load     func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error)
+}
+
+// sourceOptions are the settings of the data sources given in the command
+// line.
+type sourceOptions struct {
+	remote             valuesloader.RemoteOptions
+	expandEnv          bool
+	secretsKeepNewline bool
+}
+
+// sourceKinds are the kinds of data sources by name. Every kind with a value
+// has a repeatable flag with the same name.
+var sourceKinds = map[string]*sourceKind{}
+
+// sourceFlags are the names of the kinds with a flag, in the order they are
+// registered when --source is not given.
+var sourceFlags = []string{}
+
+// fileKinds are the kinds used by the file kind by file extension.
+var fileKinds = map[string]string{}
+
+func registerSourceKind(kind *sourceKind) {
+	sourceKinds[kind.name] = kind
+	if kind.hasValue {
+		sourceFlags = append(sourceFlags, kind.name)
+	}
+}
+
+// sourceUsage returns the usage of the flag of the kind named name, with the
+// environment variable of the kind, if any, as cli shows it for the other
+// flags.
+func sourceUsage(name, usage string) string {
+	if envVar := sourceKinds[name].envVar; envVar != "" {
+		return usage + " [$" + envVar + "]"
+	}
+	return usage
+}
+
+func init() {
+	registerSourceKind(&sourceKind{
+		name:     "env",
+		exitCode: exitEnvironmentLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.EnvironmentLoader(ctx)
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "json",
+		hasValue: true,
+		envVar:   "RUN_JSON",
+		exitCode: exitJSONLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.JSONLoader(ctx, []byte(value))
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "remote-json",
+		hasValue: true,
+		url:      true,
+		envVar:   "RUN_REMOTE_JSON",
+		exitCode: exitRemoteJSONLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.RemoteJSONLoader(ctx, value, opts.remote)
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "json-file",
+		hasValue: true,
+		envVar:   "RUN_JSON_FILE",
+		exitCode: exitJSONFileLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.JSONFileLoader(ctx, value)
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "aws-secret",
+		hasValue: true,
+		envVar:   "RUN_AWS_SECRET_ARN",
+		exitCode: exitAWSSecretsLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.AWSSecretsManagerLoader(ctx, value, opts.remote)
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "yaml",
+		hasValue: true,
+		envVar:   "RUN_YAML",
+		exitCode: exitYAMLLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.YAMLLoader(ctx, []byte(value))
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "remote-yaml",
+		hasValue: true,
+		url:      true,
+		envVar:   "RUN_REMOTE_YAML",
+		exitCode: exitRemoteYAMLLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.RemoteYAMLLoader(ctx, value, opts.remote)
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "yaml-file",
+		hasValue: true,
+		envVar:   "RUN_YAML_FILE",
+		exitCode: exitYAMLFileLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.YAMLFileLoader(ctx, value)
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "toml",
+		hasValue: true,
+		envVar:   "RUN_TOML",
+		exitCode: exitTOMLLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.TOMLLoader(ctx, []byte(value))
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "toml-file",
+		hasValue: true,
+		envVar:   "RUN_TOML_FILE",
+		exitCode: exitTOMLFileLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.TOMLFileLoader(ctx, value)
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "values-env-file",
+		hasValue: true,
+		envVar:   "RUN_VALUES_ENV_FILE",
+		exitCode: exitDotenvFileLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.DotenvFileLoader(ctx, value, opts.expandEnv)
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "ini-file",
+		hasValue: true,
+		envVar:   "RUN_INI_FILE",
+		exitCode: exitINIFileLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.INIFileLoader(ctx, value)
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "properties-file",
+		hasValue: true,
+		envVar:   "RUN_PROPERTIES_FILE",
+		exitCode: exitPropertiesFileLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.PropertiesFileLoader(ctx, value)
+		},
+	})
+	registerSourceKind(&sourceKind{
+		name:     "secrets-dir",
+		hasValue: true,
+		envVar:   "RUN_SECRETS_DIR",
+		exitCode: exitDirectoryLoader,
+		load: func(ctx context.Context, value string, opts *sourceOptions) (valuesloader.ValueLoaderFunc, error) {
+			return valuesloader.DirectoryLoader(ctx, value, !opts.secretsKeepNewline)
+		},
+	})
+	fileKinds[".json"] = "json-file"
+	fileKinds[".yaml"] = "yaml-file"
+	fileKinds[".yml"] = "yaml-file"
+	fileKinds[".toml"] = "toml-file"
+	fileKinds[".env"] = "values-env-file"
+	fileKinds[".ini"] = "ini-file"
+	fileKinds[".properties"] = "properties-file"
+}
+
+// source is a data source given in the command line.
+type source struct {
+	kind  *sourceKind
+	value string
+}
+
+// parseSource parses a --source value, either a kind with no value, like env,
+// or kind:value. The file kind is resolved to the kind of the file extension.
+func parseSource(value string) (*source, error) {
+	name, arg := value, ""
+	hasArg := false
+	if i := strings.Index(value, ":"); i != -1 {
+		name, arg, hasArg = value[:i], value[i+1:], true
+	}
+
+	if name == "file" {
+		ext := strings.ToLower(filepath.Ext(arg))
+		if fileKinds[ext] == "" {
+			return nil, fmt.Errorf("unknown file type %q in source %q", ext, value)
+		}
+		name = fileKinds[ext]
+	}
+
+	kind, ok := sourceKinds[name]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("unknown source kind %q in source %q", name, value)
+	case kind.hasValue && arg == "":
+		return nil, fmt.Errorf("invalid source %q, expected %s:value", value, name)
+	case !kind.hasValue && hasArg:
+		return nil, fmt.Errorf("invalid source %q, %s takes no value", value, name)
+	}
+	return &source{kind: kind, value: arg}, nil
+}
+
+// sources returns the data sources given in the command line in order of
+// precedence. The --source values come first, followed by the values of the
+// flag of every kind. When --source is not given the environment comes first.
+func sources(c *cli.Context) ([]*source, error) {
+	list := []*source{}
+	for _, value := range c.StringSlice("source") {
+		src, err := parseSource(value)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, src)
+	}
+	if len(list) == 0 {
+		list = append(list, &source{kind: sourceKinds["env"]})
+	}
+
+	for _, name := range sourceFlags {
+		kind := sourceKinds[name]
+		values := c.StringSlice(name)
+		if len(values) == 0 && kind.envVar != "" && os.Getenv(kind.envVar) != "" {
+			values = []string{os.Getenv(kind.envVar)}
+		}
+		for _, value := range values {
+			list = append(list, &source{kind: kind, value: value})
+		}
+	}
+	return list, nil
+}
+
+// loadValues creates a ValuesLoader with a loader for every data source given
+// in the command line. It returns an exit error if any loader fails.
+func loadValues(c *cli.Context) (*valuesloader.ValuesLoader, error) {
+	remote, err := remoteOptions(c)
+	if err != nil {
+		return nil, newExitError(err, exitInvalidOption)
+	}
+	opts := &sourceOptions{
+		remote:             remote,
+		expandEnv:          c.Bool("values-env-expand"),
+		secretsKeepNewline: c.Bool("secrets-keep-newline"),
+	}
+
+	list, err := sources(c)
+	if err != nil {
+		return nil, newExitError(err, exitInvalidOption)
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	loaderFuncs := []valuesloader.ValueLoaderFunc{}
+	for _, src := range list {
+		value := src.value
+		if src.kind.url {
+			value = valuesloader.RedactURL(value)
+		}
+		logger.Printf("Registering %s loader with value %s", src.kind.name, value)
+		loader, err := src.kind.load(ctx, src.value, opts)
+		if err != nil {
+			return nil, newExitError(err, src.kind.exitCode)
+		}
+		loaderFuncs = append(loaderFuncs, loader)
+	}
+
+	logger.Printf("Creating ValuesLoader")
+	vl, err := valuesloader.New(loaderFuncs...)
+	if err != nil {
+		return nil, newExitError(err, exitValuesLoader)
+	}
+	return vl, nil
+}
+
+// remoteOptions returns the options of the remote loaders set in the command
+// line.
+func remoteOptions(c *cli.Context) (valuesloader.RemoteOptions, error) {
+	opts := valuesloader.DefaultRemoteOptions
+	opts.ConnectTimeout = c.Duration("remote-connect-timeout")
+	opts.ReadTimeout = c.Duration("remote-read-timeout")
+	opts.Retries = c.Int("remote-retries")
+	opts.Backoff = c.Duration("remote-backoff")
+	opts.CAFile = c.String("remote-ca-file")
+	opts.CertFile = c.String("remote-cert-file")
+	opts.KeyFile = c.String("remote-key-file")
+	opts.Proxy = c.String("remote-proxy")
+
+	opts.Header = http.Header{}
+	for _, value := range c.StringSlice("remote-json-header") {
+		i := strings.Index(value, ":")
+		if i == -1 || strings.TrimSpace(value[:i]) == "" {
+			return opts, fmt.Errorf("invalid header %q, expected name: value", value)
+		}
+		opts.Header.Add(strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:]))
+	}
+
+	switch {
+	case opts.ConnectTimeout < 0:
+		return opts, fmt.Errorf("invalid remote connect timeout %s", opts.ConnectTimeout)
+	case opts.ReadTimeout < 0:
+		return opts, fmt.Errorf("invalid remote read timeout %s", opts.ReadTimeout)
+	case opts.Retries < 0:
+		return opts, fmt.Errorf("invalid remote retries %d", opts.Retries)
+	case opts.Backoff < 0:
+		return opts, fmt.Errorf("invalid remote backoff %s", opts.Backoff)
+	}
+	return opts, nil
+}
+
+// interruptContext returns a context that is canceled when the current
+// process receives SIGINT or SIGTERM before stop is called.
+func interruptContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
@@ -0,0 +1,222 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/txgruppi/run/logger"
+	"github.com/txgruppi/run/text"
+	"github.com/txgruppi/run/valuesloader"
+	"github.com/urfave/cli"
+)
+
+// renderOptions are the settings used to render the templates and the env
+// file. When hasInput is true the first template is the one given by --input.
+type renderOptions struct {
+	delims       text.Delims
+	templates    []*template
+	hasInput     bool
+	envFile      string
+	envOutputVar string
+	strict       bool
+	output       outputOptions
+}
+
+// rendered holds the rendered templates, in the same order as the templates
+// in renderOptions, and the environment for the command, which is nil when the
+// command inherits the environment of the current process.
+type rendered struct {
+	outputs [][]byte
+	env     []string
+}
+
+// changed reports whether the rendered templates and the environment differ
+// from the ones in other.
+func (r *rendered) changed(other *rendered) (outputs bool, env bool) {
+	return !reflect.DeepEqual(r.outputs, other.outputs), !reflect.DeepEqual(r.env, other.env)
+}
+
+// renderAll loads the values from the data sources and renders every template
+// and the env file. It returns an exit error if anything fails.
+func renderAll(c *cli.Context, opts *renderOptions) (*rendered, error) {
+	var unresolved unresolvedError
+	r := &rendered{}
+
+	vl, err := loadValues(c)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range opts.templates {
+		logger.Printf("Reading template %s", t.input)
+		data, err := ioutil.ReadFile(t.input)
+		if err != nil {
+			return nil, newExitError(err, exitTemplateRead)
+		}
+
+		logger.Printf("Finding template tokens")
+		tokens, err := opts.delims.Tokens(data)
+		if err != nil {
+			return nil, newExitError(fmt.Errorf("%s:%v", t.input, err), exitTemplateRender)
+		}
+
+		logger.Printf("Rendering template")
+		out, missing, err := render(t.input, data, tokens, vl)
+		if err != nil {
+			return nil, newExitError(err, exitTemplateRender)
+		}
+		unresolved = unresolved.add(t.input, missing)
+		r.outputs = append(r.outputs, out)
+	}
+
+	if opts.envFile != "" {
+		logger.Printf("Reading env file %s", opts.envFile)
+		envData, err := ioutil.ReadFile(opts.envFile)
+		if err != nil {
+			return nil, newExitError(err, exitEnvFileRead)
+		}
+
+		logger.Printf("Finding env file tokens")
+		envTokens, err := opts.delims.Tokens(envData)
+		if err != nil {
+			return nil, newExitError(fmt.Errorf("%s:%v", opts.envFile, err), exitEnvFileRender)
+		}
+
+		logger.Printf("Rendering env file")
+		envRender, missing, err := render(opts.envFile, envData, envTokens, vl)
+		if err != nil {
+			return nil, newExitError(err, exitEnvFileRender)
+		}
+		unresolved = unresolved.add(opts.envFile, missing)
+
+		logger.Printf("Getting complete environment values")
+		r.env, err = environ(envRender)
+		if err != nil {
+			return nil, newExitError(err, exitEnviron)
+		}
+	}
+
+	for _, msg := range unresolved {
+		logger.Printf("Unresolved token at %s", msg)
+	}
+	if opts.strict && len(unresolved) > 0 {
+		return nil, newExitError(unresolved, exitUnresolvedTokens)
+	}
+
+	if opts.envOutputVar != "" && opts.hasInput {
+		inputRender := r.outputs[0]
+		logger.Printf("Creating output environment variable with value:")
+		logger.Printf(string(inputRender))
+		pair := opts.envOutputVar + "=" + string(inputRender)
+		if r.env == nil {
+			logger.Printf("Getting complete environment values")
+			r.env, err = environ([]byte(pair))
+			if err != nil {
+				return nil, newExitError(err, exitEnviron)
+			}
+		} else {
+			logger.Printf("Adding output environment variable")
+			r.env = append(r.env, pair)
+		}
+	}
+
+	return r, nil
+}
+
+// writeAll writes every rendered template with an output file. It returns an
+// exit error if any file cannot be written.
+func writeAll(opts *renderOptions, r *rendered) error {
+	for i, t := range opts.templates {
+		if t.output == "" {
+			continue
+		}
+		logger.Printf("Writing output file %s", t.output)
+		if err := writeOutput(t.output, r.outputs[i], opts.output); err != nil {
+			return newExitError(err, exitOutputWrite)
+		}
+	}
+	return nil
+}
+
+// render replaces the tokens in the input data by the values found in vl,
+// falling back to the token's literal default, and runs them through the
+// token's filters. The tokens that could not be resolved at all are replaced by
+// an empty string and returned in missing. file is only used in error messages.
+func render(file string, in []byte, tks []*text.Token, vl *valuesloader.ValuesLoader) (out []byte, missing []*text.Token, err error) {
+	var buf bytes.Buffer
+	buf.Grow(len(in))
+
+	err = text.Render(&buf, in, tks, func(token *text.Token) (string, error) {
+		value, ok := lookup(token, vl)
+		if !ok {
+			missing = append(missing, token)
+		}
+		value, err := token.ApplyFilters(value)
+		if err != nil {
+			return "", fmt.Errorf("%s:%d:%d: %v", file, token.Line, token.Column, err)
+		}
+		return value, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), missing, nil
+}
+
+// lookup returns the value of the first key of the token found in vl or the
+// token's literal default.
+func lookup(token *text.Token, vl *valuesloader.ValuesLoader) (string, bool) {
+	for _, key := range token.Keys {
+		if value, ok := vl.Lookup(key); ok {
+			return value, true
+		}
+	}
+	if token.HasDefault {
+		return token.Default, true
+	}
+	return "", false
+}
+
+// environ returns the environment of the current process followed by the
+// variables in envData, sorted by name.
+func environ(envData []byte) ([]string, error) {
+	r := bytes.NewReader(envData)
+	em, err := godotenv.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(em))
+	for k := range em {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := os.Environ()
+	for _, k := range keys {
+		out = append(out, k+"="+em[k])
+	}
+
+	return out, nil
+}
+
+// unresolvedError lists the tokens that could not be resolved by any loader,
+// one "file:line:column: token" entry per token.
+type unresolvedError []string
+
+func (e unresolvedError) add(file string, tks []*text.Token) unresolvedError {
+	for _, token := range tks {
+		e = append(e, fmt.Sprintf("%s:%d:%d: %s", file, token.Line, token.Column, token.Raw))
+	}
+	return e
+}
+
+func (e unresolvedError) Error() string {
+	return "unresolved tokens:\n  " + strings.Join(e, "\n  ")
+}
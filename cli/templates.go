@@ -0,0 +1,229 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// templateSuffixes are the extensions of the files rendered from a template
+// directory. They are removed from the output file names.
+var templateSuffixes = []string{".dist", ".tmpl"}
+
+// template is a file to be rendered to output. An empty output means the
+// rendered data is only kept in memory.
+type template struct {
+	input  string
+	output string
+}
+
+// parseTemplatePair parses an "input:output" pair.
+func parseTemplatePair(value string) (*template, error) {
+	i := strings.Index(value, ":")
+	if i <= 0 || i == len(value)-1 {
+		return nil, fmt.Errorf("invalid template %q, expected input:output", value)
+	}
+	return &template{input: value[:i], output: value[i+1:]}, nil
+}
+
+// findTemplates returns a template for every file under the input directory
+// of dirs with one of the templateSuffixes. The outputs mirror the input tree
+// under the output directory of dirs.
+func findTemplates(dirs *template) ([]*template, error) {
+	templates := []*template{}
+	err := filepath.Walk(dirs.input, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		for _, suffix := range templateSuffixes {
+			if !strings.HasSuffix(path, suffix) {
+				continue
+			}
+			rel, err := filepath.Rel(dirs.input, path)
+			if err != nil {
+				return err
+			}
+			templates = append(templates, &template{
+				input:  path,
+				output: filepath.Join(dirs.output, strings.TrimSuffix(rel, suffix)),
+			})
+			return nil
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// outputOptions are the attributes of the rendered files. An uid or gid of -1
+// keeps the one of the current process.
+type outputOptions struct {
+	mode os.FileMode
+	uid  int
+	gid  int
+}
+
+// parseOutputOptions parses an octal file mode and an optional "user:group"
+// owner, where both user and group can be names or numeric ids and either
+// one can be omitted.
+func parseOutputOptions(mode, owner string) (outputOptions, error) {
+	opts := outputOptions{uid: -1, gid: -1}
+
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return opts, fmt.Errorf("invalid output mode %q", mode)
+	}
+	opts.mode = os.FileMode(perm)
+
+	if owner == "" {
+		return opts, nil
+	}
+	name, group := owner, ""
+	if i := strings.Index(owner, ":"); i != -1 {
+		name, group = owner[:i], owner[i+1:]
+	}
+	if name != "" {
+		opts.uid, err = lookupID(name, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return opts, err
+		}
+	}
+	if group != "" {
+		opts.gid, err = lookupID(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// lookupID returns value as a number if it is numeric or the id returned by
+// lookup otherwise.
+func lookupID(value string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+	id, err := lookup(value)
+	if err != nil {
+		return -1, err
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return -1, fmt.Errorf("non numeric id %q for %q", id, value)
+	}
+	return n, nil
+}
+
+// writeOutput atomically writes data to file, creating its parent directories.
+// The data is written to a temporary file in the same directory which is then
+// renamed to file, so file is never left half written. When file cannot be
+// replaced, like a single file bind mount or a file in a directory that is not
+// writable, it is written in place instead.
+func writeOutput(file string, data []byte, opts outputOptions) (err error) {
+	// Fail with the usual errors if file exists but cannot be written.
+	if f, err := os.OpenFile(file, os.O_WRONLY, 0); err == nil {
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(file)+".")
+	if err != nil {
+		if cannotReplace(err) {
+			return writeInPlace(file, data, opts)
+		}
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(opts.mode); err != nil {
+		return err
+	}
+	if opts.uid != -1 || opts.gid != -1 {
+		if err = tmp.Chown(opts.uid, opts.gid); err != nil {
+			return err
+		}
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), file); err != nil && cannotReplace(err) {
+		os.Remove(tmp.Name())
+		return writeInPlace(file, data, opts)
+	}
+	return err
+}
+
+// cannotReplace reports whether err means that a file cannot be replaced by a
+// rename, but may still be written in place.
+func cannotReplace(err error) bool {
+	return errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EXDEV) || errors.Is(err, syscall.EACCES)
+}
+
+// writeInPlace truncates file and writes data to it. The mode is only changed
+// when it differs, so a file owned by another user can still be written.
+func writeInPlace(file string, data []byte, opts outputOptions) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, opts.mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm() != opts.mode {
+		if err := f.Chmod(opts.mode); err != nil {
+			return err
+		}
+	}
+	if opts.uid != -1 || opts.gid != -1 {
+		if err := f.Chown(opts.uid, opts.gid); err != nil {
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
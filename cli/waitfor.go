@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/txgruppi/run/logger"
+)
+
+// dependency is a service or file the command depends on. check returns nil
+// when it is ready.
+type dependency struct {
+	raw   string
+	check func(ctx context.Context) error
+}
+
+// parseDependency parses a --wait-for value, either tcp://host:port,
+// http://url, https://url or file:path.
+func parseDependency(value string) (*dependency, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dependency %q: %v", value, err)
+	}
+
+	dep := &dependency{raw: value}
+	switch u.Scheme {
+	case "tcp":
+		if u.Port() == "" {
+			return nil, fmt.Errorf("invalid dependency %q, expected tcp://host:port", value)
+		}
+		dep.check = func(ctx context.Context) error {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", u.Host)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+
+	case "http", "https":
+		dep.check = func(ctx context.Context) error {
+			return checkHTTP(ctx, value)
+		}
+
+	case "file":
+		path := u.Path
+		if u.Opaque != "" {
+			path = u.Opaque
+		}
+		if path == "" {
+			return nil, fmt.Errorf("invalid dependency %q, expected file:path", value)
+		}
+		dep.check = func(ctx context.Context) error {
+			_, err := os.Stat(path)
+			return err
+		}
+
+	default:
+		return nil, fmt.Errorf("invalid dependency %q, expected tcp://, http://, https:// or file:", value)
+	}
+	return dep, nil
+}
+
+// checkHTTP returns nil if a GET request to rawurl succeeds with a 2xx status.
+func checkHTTP(ctx context.Context, rawurl string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawurl, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+// waitOptions are the settings of --wait-for. Every check of a dependency
+// takes at most timeout, failed checks are retried every interval and all
+// the dependencies must be ready before deadline, counted from the start.
+type waitOptions struct {
+	dependencies []*dependency
+	timeout      time.Duration
+	interval     time.Duration
+	deadline     time.Duration
+}
+
+// parseWaitOptions parses the --wait-for, --wait-timeout, --wait-interval and
+// --wait-deadline options.
+func parseWaitOptions(values []string, timeout, interval, deadline time.Duration) (*waitOptions, error) {
+	if timeout <= 0 {
+		return nil, fmt.Errorf("invalid wait timeout %s", timeout)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid wait interval %s", interval)
+	}
+	if deadline <= 0 {
+		return nil, fmt.Errorf("invalid wait deadline %s", deadline)
+	}
+
+	opts := &waitOptions{timeout: timeout, interval: interval, deadline: deadline}
+	for _, value := range values {
+		dep, err := parseDependency(value)
+		if err != nil {
+			return nil, err
+		}
+		opts.dependencies = append(opts.dependencies, dep)
+	}
+	return opts, nil
+}
+
+// waitForDependencies waits for every dependency in order. It returns an exit
+// error if any of them is not ready before the deadline.
+func waitForDependencies(opts *waitOptions) error {
+	deadline := time.Now().Add(opts.deadline)
+
+	for _, dep := range opts.dependencies {
+		logger.Printf("Waiting for %s", dep.raw)
+		for {
+			timeout := opts.timeout
+			if remaining := time.Until(deadline); remaining < timeout {
+				timeout = remaining
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			err := dep.check(ctx)
+			cancel()
+			if err == nil {
+				break
+			}
+
+			logger.Printf("Dependency %s is not ready: %v", dep.raw, err)
+			if time.Until(deadline) < opts.interval {
+				return newExitError(fmt.Errorf("dependency %s is not ready: %v", dep.raw, err), exitWaitFor)
+			}
+			time.Sleep(opts.interval)
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 module github.com/txgruppi/run
 
 require (
+	github.com/BurntSushi/toml v0.4.1
 	github.com/aws/aws-sdk-go v1.25.30
 	github.com/davecgh/go-spew v1.1.1
 	github.com/joho/godotenv v1.3.0
@@ -8,6 +9,7 @@ require (
 	github.com/urfave/cli v1.19.1
 	github.com/valyala/fastjson v1.3.0
 	golang.org/x/net v0.0.0-20191108063844-7e6e90b9ea88 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 go 1.13
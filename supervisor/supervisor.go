@@ -0,0 +1,196 @@
+// Package supervisor runs commands as children of the current process. While
+// a command runs, the signals received by the current process are forwarded to
+// it. On Unix systems every child is waited for by a single reaper, so
+// orphaned processes are reaped as well when the current process is the init
+// process of a container. Because of that, commands started by other means,
+// like exec.Cmd.Run, must not be used alongside this package.
+package supervisor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ParseSignal returns the signal with the given name, like SIGHUP or HUP. The
+// name is case insensitive.
+func ParseSignal(name string) (os.Signal, error) {
+	key := strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	if sig, ok := signals[key]; ok {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unknown signal %q", name)
+}
+
+// Status is the exit status of a process.
+type Status struct {
+	// Code is the exit code of the process or -1 if it was killed by a signal.
+	Code int
+	// Signal is the signal that killed the process, if any.
+	Signal os.Signal
+}
+
+// ExitCode returns the exit code of the process or 128 plus the signal number
+// if it was killed by a signal, like shells do.
+func (s Status) ExitCode() int {
+	if sig, ok := s.Signal.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return s.Code
+}
+
+// Process is a command started by Start.
+type Process struct {
+	cmd    *exec.Cmd
+	done   chan struct{}
+	status Status
+}
+
+// Start starts cmd and forwards the signals received by the current process
+// to it until it exits. The Stdin, Stdout and Stderr of cmd that are not files
+// are connected through pipes.
+func Start(cmd *exec.Cmd) (*Process, error) {
+	p := &Process{
+		cmd:  cmd,
+		done: make(chan struct{}),
+	}
+
+	var pp pipes
+	var err error
+	if cmd.Stdin, err = pp.input(cmd.Stdin); err != nil {
+		pp.closeAll()
+		return nil, err
+	}
+	if cmd.Stdout, err = pp.output(cmd.Stdout); err != nil {
+		pp.closeAll()
+		return nil, err
+	}
+	if cmd.Stderr, err = pp.output(cmd.Stderr); err != nil {
+		pp.closeAll()
+		return nil, err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+
+	wait, err := start(cmd)
+	pp.closeAll()
+	if err != nil {
+		signal.Stop(signals)
+		pp.copies.Wait()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				p.Signal(sig)
+			case <-p.done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		status := wait()
+		signal.Stop(signals)
+		pp.copies.Wait()
+		p.status = status
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+// Pid returns the process id.
+func (p *Process) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+// Signal sends sig to the process.
+func (p *Process) Signal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+// Stop sends sig to the process and waits for it to exit. The process is
+// killed if it is still running after timeout.
+func (p *Process) Stop(sig os.Signal, timeout time.Duration) Status {
+	p.Signal(sig)
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		p.Signal(os.Kill)
+	}
+	return p.Wait()
+}
+
+// Done returns a channel that is closed when the process exits.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait waits for the process to exit and returns its exit status.
+func (p *Process) Wait() Status {
+	<-p.done
+	return p.status
+}
+
+// pipes connects the standard streams of a command that are not files.
+type pipes struct {
+	closeAfterStart []io.Closer
+	copies          sync.WaitGroup
+}
+
+func (pp *pipes) input(r io.Reader) (io.Reader, error) {
+	if r == nil {
+		return nil, nil
+	}
+	if _, ok := r.(*os.File); ok {
+		return r, nil
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	pp.closeAfterStart = append(pp.closeAfterStart, pr)
+	go func() {
+		io.Copy(pw, r)
+		pw.Close()
+	}()
+	return pr, nil
+}
+
+func (pp *pipes) output(w io.Writer) (io.Writer, error) {
+	if w == nil {
+		return nil, nil
+	}
+	if _, ok := w.(*os.File); ok {
+		return w, nil
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	pp.closeAfterStart = append(pp.closeAfterStart, pw)
+	pp.copies.Add(1)
+	go func() {
+		defer pp.copies.Done()
+		io.Copy(w, pr)
+		pr.Close()
+	}()
+	return pw, nil
+}
+
+func (pp *pipes) closeAll() {
+	for _, c := range pp.closeAfterStart {
+		c.Close()
+	}
+	pp.closeAfterStart = nil
+}
@@ -0,0 +1,155 @@
+//go:build !windows
+// +build !windows
+
+package supervisor_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txgruppi/run/supervisor"
+)
+
+func TestSupervisor(t *testing.T) {
+	t.Run("output and exit code", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", "echo out; echo err >&2; exit 3")
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		proc, err := supervisor.Start(cmd)
+		require.Nil(t, err)
+		require.NotZero(t, proc.Pid())
+
+		status := proc.Wait()
+		require.Equal(t, supervisor.Status{Code: 3}, status)
+		require.Equal(t, 3, status.ExitCode())
+		require.Equal(t, "out\n", stdout.String())
+		require.Equal(t, "err\n", stderr.String())
+
+		select {
+		case <-proc.Done():
+		default:
+			t.Fatal("done channel is not closed")
+		}
+	})
+
+	t.Run("killed by a signal", func(t *testing.T) {
+		proc, err := supervisor.Start(exec.Command("sleep", "10"))
+		require.Nil(t, err)
+
+		require.Nil(t, proc.Signal(syscall.SIGTERM))
+
+		status := proc.Wait()
+		require.Equal(t, supervisor.Status{Code: -1, Signal: syscall.SIGTERM}, status)
+		require.Equal(t, 128+15, status.ExitCode())
+	})
+
+	t.Run("forward signals", func(t *testing.T) {
+		var stdout bytes.Buffer
+		cmd := exec.Command("sh", "-c", `trap "echo got usr1; exit 7" USR1; echo ready; while true; do sleep 0.05; done`)
+		cmd.Stdout = &stdout
+
+		proc, err := supervisor.Start(cmd)
+		require.Nil(t, err)
+
+		time.Sleep(200 * time.Millisecond)
+		require.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+
+		select {
+		case <-proc.Done():
+		case <-time.After(5 * time.Second):
+			proc.Signal(syscall.SIGKILL)
+			t.Fatal("signal was not forwarded")
+		}
+
+		require.Equal(t, 7, proc.Wait().ExitCode())
+		require.Equal(t, "ready\ngot usr1\n", stdout.String())
+	})
+
+	t.Run("many short lived processes", func(t *testing.T) {
+		procs := []*supervisor.Process{}
+		for i := 0; i < 20; i++ {
+			proc, err := supervisor.Start(exec.Command("true"))
+			require.Nil(t, err)
+			procs = append(procs, proc)
+		}
+		for _, proc := range procs {
+			require.Equal(t, 0, proc.Wait().ExitCode())
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		proc, err := supervisor.Start(exec.Command("sleep", "10"))
+		require.Nil(t, err)
+
+		status := proc.Stop(syscall.SIGTERM, 5*time.Second)
+		require.Equal(t, supervisor.Status{Code: -1, Signal: syscall.SIGTERM}, status)
+	})
+
+	t.Run("stop kills after timeout", func(t *testing.T) {
+		cmd := exec.Command("sh", "-c", `trap "" TERM; echo ready; while true; do sleep 0.05; done`)
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+
+		proc, err := supervisor.Start(cmd)
+		require.Nil(t, err)
+		time.Sleep(200 * time.Millisecond)
+
+		status := proc.Stop(syscall.SIGTERM, 200*time.Millisecond)
+		require.Equal(t, supervisor.Status{Code: -1, Signal: syscall.SIGKILL}, status)
+	})
+
+	t.Run("command not found", func(t *testing.T) {
+		proc, err := supervisor.Start(exec.Command("some-command-that-does-not-exist-for-run"))
+		require.Nil(t, proc)
+		require.Error(t, err)
+	})
+}
+
+func TestExec(t *testing.T) {
+	t.Run("replaces the process", func(t *testing.T) {
+		var stdout bytes.Buffer
+		cmd := exec.Command(os.Args[0], "-test.run=TestExecHelperProcess")
+		cmd.Env = append(os.Environ(), "RUN_TEST_EXEC_HELPER=1")
+		cmd.Stdout = &stdout
+
+		proc, err := supervisor.Start(cmd)
+		require.Nil(t, err)
+
+		status := proc.Wait()
+		require.Equal(t, 5, status.ExitCode())
+		require.Equal(t, "replaced 1\n", stdout.String())
+	})
+
+	t.Run("command not found", func(t *testing.T) {
+		err := supervisor.Exec("some-command-that-does-not-exist-for-run", nil, os.Environ())
+		require.Error(t, err)
+	})
+}
+
+// TestExecHelperProcess is not a real test, it is run by TestExec in a child
+// process which is replaced by a shell.
+func TestExecHelperProcess(t *testing.T) {
+	if os.Getenv("RUN_TEST_EXEC_HELPER") != "1" {
+		return
+	}
+	err := supervisor.Exec("sh", []string{"-c", `echo replaced $RUN_TEST_EXEC_HELPER; exit 5`}, os.Environ())
+	t.Fatal(err)
+}
+
+func TestParseSignal(t *testing.T) {
+	for _, name := range []string{"SIGHUP", "HUP", "hup", "SigHup"} {
+		sig, err := supervisor.ParseSignal(name)
+		require.Nil(t, err)
+		require.Equal(t, syscall.SIGHUP, sig)
+	}
+
+	_, err := supervisor.ParseSignal("SIGNOPE")
+	require.EqualError(t, err, `unknown signal "SIGNOPE"`)
+}
@@ -0,0 +1,116 @@
+//go:build !windows
+// +build !windows
+
+package supervisor
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// forwardedSignals are the signals forwarded to the running processes. Keep
+// the list in the README in sync.
+var forwardedSignals = []os.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGWINCH,
+	syscall.SIGALRM,
+}
+
+// signals are the signals accepted by ParseSignal, by name without the SIG
+// prefix.
+var signals = map[string]os.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"TERM":  syscall.SIGTERM,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"WINCH": syscall.SIGWINCH,
+	"ALRM":  syscall.SIGALRM,
+}
+
+// reaper waits for every child of the current process. The processes started
+// by this package are registered in waiting to receive their status.
+var reaper struct {
+	sync.Mutex
+	once    sync.Once
+	waiting map[int]chan syscall.WaitStatus
+}
+
+// start starts cmd and returns a function that waits for it to exit. The
+// reaper is locked while cmd starts so it cannot be reaped before it is
+// registered.
+func start(cmd *exec.Cmd) (func() Status, error) {
+	reaper.once.Do(startReaper)
+
+	reaper.Lock()
+	defer reaper.Unlock()
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	ch := make(chan syscall.WaitStatus, 1)
+	reaper.waiting[cmd.Process.Pid] = ch
+
+	return func() Status {
+		ws := <-ch
+		if ws.Signaled() {
+			return Status{Code: -1, Signal: ws.Signal()}
+		}
+		return Status{Code: ws.ExitStatus()}
+	}, nil
+}
+
+func startReaper() {
+	reaper.waiting = map[int]chan syscall.WaitStatus{}
+
+	sigchld := make(chan os.Signal, 1)
+	signal.Notify(sigchld, syscall.SIGCHLD)
+	go func() {
+		for range sigchld {
+			reap()
+		}
+	}()
+}
+
+// reap waits for every child that already exited, delivering the status of the
+// registered ones. The others are orphans adopted by the current process.
+func reap() {
+	reaper.Lock()
+	defer reaper.Unlock()
+
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			return
+		}
+		if ch, ok := reaper.waiting[pid]; ok {
+			ch <- ws
+			delete(reaper.waiting, pid)
+		}
+	}
+}
+
+// Exec replaces the current process by the command name, looked up in the
+// PATH like exec.Command does, with the given arguments and environment. It
+// only returns on failure.
+func Exec(name string, args []string, env []string) error {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, append([]string{name}, args...), env)
+}
@@ -0,0 +1,37 @@
+package supervisor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// forwardedSignals are the signals forwarded to the running processes. The
+// console already delivers them to every process attached to it, they are
+// only caught so the current process outlives its children.
+var forwardedSignals = []os.Signal{
+	os.Interrupt,
+}
+
+// signals are the signals accepted by ParseSignal, by name without the SIG
+// prefix.
+var signals = map[string]os.Signal{
+	"INT":  os.Interrupt,
+	"KILL": os.Kill,
+}
+
+// start starts cmd and returns a function that waits for it to exit.
+func start(cmd *exec.Cmd) (func() Status, error) {
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return func() Status {
+		cmd.Wait()
+		return Status{Code: cmd.ProcessState.ExitCode()}
+	}, nil
+}
+
+// Exec is not supported on Windows, it always returns an error.
+func Exec(name string, args []string, env []string) error {
+	return errors.New("exec mode is not supported on windows")
+}
@@ -0,0 +1,122 @@
+package text
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/valyala/fastjson"
+	"gopkg.in/yaml.v2"
+)
+
+// FilterFunc transforms the value of a token.
+type FilterFunc func(value string) (string, error)
+
+var filters = map[string]FilterFunc{
+	"upper":        func(value string) (string, error) { return strings.ToUpper(value), nil },
+	"lower":        func(value string) (string, error) { return strings.ToLower(value), nil },
+	"trim":         func(value string) (string, error) { return strings.TrimSpace(value), nil },
+	"base64":       base64Encode,
+	"base64decode": base64Decode,
+	"json":         jsonEncode,
+	"urlquery":     func(value string) (string, error) { return url.QueryEscape(value), nil },
+	"sha256":       sha256Sum,
+	"yaml":         yamlEncode,
+}
+
+// RegisterFilter makes fn available to tokens as name, replacing any filter
+// previously registered with the same name. It is not safe to call it while
+// tokens are being rendered.
+func RegisterFilter(name string, fn FilterFunc) {
+	filters[name] = fn
+}
+
+// Filter returns the filter registered as name.
+func Filter(name string) (FilterFunc, bool) {
+	fn, ok := filters[name]
+	return fn, ok
+}
+
+func base64Encode(value string) (string, error) {
+	return base64.StdEncoding.EncodeToString([]byte(value)), nil
+}
+
+func base64Decode(value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// jsonEncode returns value as a quoted JSON string.
+func jsonEncode(value string) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func sha256Sum(value string) (string, error) {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// yamlEncode converts a JSON document, like the objects and arrays returned by
+// valuesloader.JSONLoader, to YAML keeping the order of the keys. Values that
+// are not valid JSON are encoded as YAML strings.
+func yamlEncode(value string) (string, error) {
+	var doc interface{} = value
+	if parsed, err := fastjson.Parse(value); err == nil {
+		doc = yamlValue(parsed)
+	}
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func yamlValue(value *fastjson.Value) interface{} {
+	switch value.Type() {
+	case fastjson.TypeObject:
+		out := yaml.MapSlice{}
+		value.GetObject().Visit(func(key []byte, v *fastjson.Value) {
+			out = append(out, yaml.MapItem{Key: string(key), Value: yamlValue(v)})
+		})
+		return out
+
+	case fastjson.TypeArray:
+		items := value.GetArray()
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			out[i] = yamlValue(item)
+		}
+		return out
+
+	case fastjson.TypeString:
+		return string(value.GetStringBytes())
+
+	case fastjson.TypeNumber:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+		return value.GetFloat64()
+
+	case fastjson.TypeTrue:
+		return true
+
+	case fastjson.TypeFalse:
+		return false
+
+	default:
+		return nil
+	}
+}
@@ -1,51 +1,240 @@
 package text
 
-func parse(data []byte) []*Token {
-	length := len(data)
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SyntaxError is returned when a template contains a malformed token. Offset
+// is the 0-based byte offset of the error, Line and Column are 1-based.
+type SyntaxError struct {
+	Msg    string
+	Offset int
+	Line   int
+	Column int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// lexer walks the template data keeping track of the current line and column.
+type lexer struct {
+	data   []byte
+	delims Delims
+	offset int
+	line   int
+	column int
+}
+
+func newLexer(data []byte, delims Delims) *lexer {
+	return &lexer{data: data, delims: delims, line: 1, column: 1}
+}
+
+func (l *lexer) eof() bool {
+	return l.offset >= len(l.data)
+}
+
+func (l *lexer) peek() byte {
+	return l.data[l.offset]
+}
+
+func (l *lexer) hasPrefix(prefix string) bool {
+	return bytes.HasPrefix(l.data[l.offset:], []byte(prefix))
+}
+
+// advance moves n bytes forward updating the line and column.
+func (l *lexer) advance(n int) {
+	for _, b := range l.data[l.offset : l.offset+n] {
+		if b == '\n' {
+			l.line++
+			l.column = 1
+			continue
+		}
+		l.column++
+	}
+	l.offset += n
+}
+
+func (l *lexer) skipSpaces() {
+	for !l.eof() && isSpace(l.peek()) {
+		l.advance(1)
+	}
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{
+		Msg:    fmt.Sprintf(format, args...),
+		Offset: l.offset,
+		Line:   l.line,
+		Column: l.column,
+	}
+}
+
+func parse(data []byte, delims Delims) ([]*Token, error) {
+	l := newLexer(data, delims)
 	tokens := []*Token{}
-	inToken := false
-	var token *Token
-	var start int
 
-	for index := 0; index < length-1; {
+	for {
+		next := bytes.Index(l.data[l.offset:], []byte(delims.Left))
+		if next == -1 {
+			return tokens, nil
+		}
+
+		backslashes := 0
+		for next-backslashes > 0 && l.data[l.offset+next-backslashes-1] == '\\' {
+			backslashes++
+		}
+		l.advance(next - backslashes)
+		if backslashes > 0 {
+			tokens = append(tokens, escapeToken(l, backslashes))
+			if backslashes%2 == 1 {
+				continue
+			}
+		}
+
+		token, err := parseToken(l)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+}
+
+// escapeToken returns a token for the given number of backslashes at the
+// current position of l, which are followed by a left delimiter. Each pair of
+// backslashes is a literal backslash and an odd backslash escapes the
+// delimiter, so the value is half the backslashes followed by the delimiter
+// when it is escaped. A delimiter that is not escaped is left for the next
+// token.
+func escapeToken(l *lexer, backslashes int) *Token {
+	token := &Token{
+		Keys:       []string{},
+		Default:    strings.Repeat("\\", backslashes/2),
+		HasDefault: true,
+		Offset:     l.offset,
+		Line:       l.line,
+		Column:     l.column,
+	}
+	n := backslashes
+	if backslashes%2 == 1 {
+		token.Default += l.delims.Left
+		n += len(l.delims.Left)
+	}
+	l.advance(n)
+	token.Raw = string(l.data[token.Offset:l.offset])
+	return token
+}
+
+// parseToken parses the token starting at the current position of l. A token
+// is a list of alternatives separated by | or ||, where the last one may be a
+// literal default, followed by filters preceded by |>.
+func parseToken(l *lexer) (*Token, error) {
+	start := *l
+	token := &Token{
+		Keys:   []string{},
+		Offset: l.offset,
+		Line:   l.line,
+		Column: l.column,
+	}
+	unterminated := func() error {
+		return start.errorf("unterminated token")
+	}
+
+	l.advance(len(l.delims.Left))
+	expectTerm := true
+	filtering := false
+
+	for {
+		l.skipSpaces()
+		if l.eof() || l.peek() == '\n' {
+			return nil, unterminated()
+		}
+
 		switch {
-		case isSpace(data[index]):
-			index++
+		case l.hasPrefix(l.delims.Left):
+			return nil, l.errorf("nested token")
 
-		case data[index] == '{' && data[index+1] == '{':
-			if inToken {
-				return nil
+		case l.hasPrefix(l.delims.Right):
+			if expectTerm && filtering {
+				return nil, l.errorf("missing filter name")
 			}
-			start = index
-			index += 2
-			inToken = true
-			token = &Token{
-				Keys: []string{},
+			if expectTerm {
+				return nil, l.errorf("empty key")
 			}
+			l.advance(len(l.delims.Right))
+			token.Raw = string(l.data[token.Offset:l.offset])
+			return token, nil
 
-		case data[index] == '}' && data[index+1] == '}':
-			if !inToken {
-				return nil
+		case l.hasPrefix("|>"):
+			if expectTerm {
+				return nil, l.errorf("empty key")
 			}
-			index += 2
-			inToken = false
-			token.Raw = string(data[start:index])
-			tokens = append(tokens, token)
+			l.advance(2)
+			expectTerm = true
+			filtering = true
 
-		case data[index] == '|' && inToken:
-			index += 1
+		case l.peek() == '|':
+			if filtering {
+				return nil, l.errorf("unexpected | after filters")
+			}
+			if expectTerm {
+				return nil, l.errorf("empty key")
+			}
+			if l.hasPrefix("||") {
+				l.advance(2)
+			} else {
+				l.advance(1)
+			}
+			expectTerm = true
+
+		case !expectTerm:
+			return nil, l.errorf("expected | or %s but found %q", l.delims.Right, l.peek())
 
-		case inToken:
-			var id []byte
-			id, index = consumeIdentifier(data, index, length)
-			token.Keys = append(token.Keys, string(id))
+		case filtering:
+			name := consumeWhile(l, isName)
+			if len(name) == 0 {
+				return nil, l.errorf("invalid character %q in filter name", l.peek())
+			}
+			token.Filters = append(token.Filters, name)
+			expectTerm = false
 
-		case !inToken:
-			index += 1
+		case token.HasDefault:
+			return nil, l.errorf("default must be the last alternative")
+
+		case l.peek() == '"':
+			literal, err := consumeString(l)
+			if err != nil {
+				return nil, err
+			}
+			token.Default = literal
+			token.HasDefault = true
+			expectTerm = false
+
+		case isNumber(l):
+			pos := *l
+			literal := consumeWhile(l, isIdentifier)
+			if _, err := strconv.ParseFloat(literal, 64); err != nil {
+				return nil, pos.errorf("invalid number %q", literal)
+			}
+			token.Default = literal
+			token.HasDefault = true
+			expectTerm = false
+
+		case isIdentifier(l.peek()) || l.peek() == '[':
+			key, err := consumeKey(l)
+			if err != nil {
+				return nil, err
+			}
+			token.Keys = append(token.Keys, key)
+			expectTerm = false
+
+		default:
+			return nil, l.errorf("invalid character %q", l.peek())
 		}
 	}
-
-	return tokens
 }
 
 func isSpace(b byte) bool {
@@ -64,18 +253,96 @@ func isUpper(b byte) bool {
 	return isInRange(b, 'A', 'Z')
 }
 
-func isIdentifier(b byte) bool {
-	return isLower(b) || isUpper(b) || b == '.' || b == '-' || b == '_'
+func isDigit(b byte) bool {
+	return isInRange(b, '0', '9')
 }
 
-func consumeIdentifier(data []byte, i, l int) ([]byte, int) {
-	id := []byte{}
-	for j := i; j < l; j++ {
-		if isIdentifier(data[j]) {
-			id = append(id, data[j])
+// isNumber reports whether the term at the current position of l is a numeric
+// literal, that is, it is only made of digits and dots with an optional
+// leading minus sign. Any other term starting with a digit is a key.
+func isNumber(l *lexer) bool {
+	term := l.data[l.offset:]
+	if len(term) > 0 && term[0] == '-' {
+		term = term[1:]
+	}
+	if len(term) == 0 || !isDigit(term[0]) {
+		return false
+	}
+	for _, b := range term {
+		if isDigit(b) || b == '.' {
 			continue
 		}
-		return id, j
+		return !isIdentifier(b) && b != '['
+	}
+	return true
+}
+
+func isIdentifier(b byte) bool {
+	return isLower(b) || isUpper(b) || isDigit(b) || b == '.' || b == '-' || b == '_'
+}
+
+func isName(b byte) bool {
+	return isLower(b) || isUpper(b) || isDigit(b) || b == '_'
+}
+
+func consumeWhile(l *lexer, fn func(byte) bool) string {
+	start := l.offset
+	for !l.eof() && fn(l.peek()) {
+		l.advance(1)
+	}
+	return string(l.data[start:l.offset])
+}
+
+// consumeKey reads a key starting at the current position of l. Besides the
+// identifier characters a key may contain index segments, like [0], and quoted
+// segments, like ["some.key"]. The key is returned as written.
+func consumeKey(l *lexer) (string, error) {
+	start := l.offset
+	for !l.eof() {
+		switch {
+		case isIdentifier(l.peek()):
+			l.advance(1)
+
+		case l.peek() == '[':
+			open := *l
+			l.advance(1)
+			if !l.eof() && l.peek() == '"' {
+				if _, err := consumeString(l); err != nil {
+					return "", err
+				}
+			} else if consumeWhile(l, isDigit) == "" {
+				return "", open.errorf("invalid key segment")
+			}
+			if l.eof() || l.peek() != ']' {
+				return "", open.errorf("unterminated key segment")
+			}
+			l.advance(1)
+
+		default:
+			return string(l.data[start:l.offset]), nil
+		}
+	}
+	return string(l.data[start:l.offset]), nil
+}
+
+// consumeString reads a double quoted string starting at the current position
+// of l. Escape sequences follow the Go syntax.
+func consumeString(l *lexer) (string, error) {
+	start := *l
+	for i := l.offset + 1; i < len(l.data); i++ {
+		switch l.data[i] {
+		case '\\':
+			i++
+		case '\n':
+			return "", start.errorf("unterminated string")
+		case '"':
+			l.advance(i + 1 - l.offset)
+			str, err := strconv.Unquote(string(l.data[start.offset:l.offset]))
+			if err != nil {
+				return "", start.errorf("invalid string %s", l.data[start.offset:l.offset])
+			}
+			return str, nil
+		}
 	}
-	return nil, l
+	return "", start.errorf("unterminated string")
 }
@@ -2,17 +2,89 @@ package text
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"strings"
 )
 
-// Tokens returns the tokens found in data.
-func Tokens(data []byte) []*Token {
+// Delims are the strings that start and end a token.
+type Delims struct {
+	Left  string
+	Right string
+}
+
+// DefaultDelims are the delimiters used by Tokens.
+var DefaultDelims = Delims{Left: "{{", Right: "}}"}
+
+// ParseDelims parses a pair of delimiters separated by white space, like
+// "<% %>".
+func ParseDelims(s string) (Delims, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return Delims{}, fmt.Errorf("invalid delimiters %q, expected a left and a right delimiter separated by a space", s)
+	}
+	d := Delims{Left: fields[0], Right: fields[1]}
+	if d.Left == d.Right {
+		return Delims{}, fmt.Errorf("invalid delimiters %q, left and right delimiters must be different", s)
+	}
+	if strings.ContainsAny(d.Left+d.Right, `|"\`) {
+		return Delims{}, fmt.Errorf("invalid delimiters %q, delimiters cannot contain |, \" or \\", s)
+	}
+	return d, nil
+}
+
+// Tokens returns the tokens found in data, in the order they appear, using
+// the default delimiters. It returns a *SyntaxError if data contains a
+// malformed token.
+func Tokens(data []byte) ([]*Token, error) {
+	return DefaultDelims.Tokens(data)
+}
+
+// Tokens works just like the package level Tokens but using d as the token
+// delimiters.
+func (d Delims) Tokens(data []byte) ([]*Token, error) {
 	if data == nil {
-		return nil
+		return nil, nil
+	}
+	return parse(data, d)
+}
+
+// ValueFunc returns the value to be written in place of a token.
+type ValueFunc func(token *Token) (string, error)
+
+// Render writes data to w replacing each token by the value returned by fn, in
+// a single pass. The tokens must be the ones returned by Tokens for data. The
+// values are written as is, they are never scanned for tokens.
+func Render(w io.Writer, data []byte, tokens []*Token, fn ValueFunc) error {
+	last := 0
+	for _, token := range tokens {
+		end := token.Offset + len(token.Raw)
+		if token.Offset < last || end > len(data) || string(data[token.Offset:end]) != token.Raw {
+			return fmt.Errorf("token %s at %d:%d does not match the data", token.Raw, token.Line, token.Column)
+		}
+
+		value, err := fn(token)
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(data[last:token.Offset]); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, value); err != nil {
+			return err
+		}
+		last = end
 	}
-	return parse(data)
+	_, err := w.Write(data[last:])
+	return err
 }
 
-// Replace replaces a token by a value in data.
+// Replace replaces a token by a value in data. Since token is the raw text of
+// the token, including its delimiters, it works with any delimiters.
+//
+// Deprecated: Replace scans the whole data for every token and replaces the
+// tokens found inside values already written. Use Render instead.
 func Replace(data []byte, token, value string) []byte {
 	if data == nil {
 		return nil
@@ -26,7 +98,34 @@ func Replace(data []byte, token, value string) []byte {
 	)
 }
 
+// Token is a placeholder found in a template. Keys are the alternatives used
+// to look up its value, in order. If none of the keys is found and HasDefault
+// is true, Default is used as the value. Filters are the names of the filters
+// the value goes through before being written. Offset is the 0-based byte
+// offset of Raw in the template, Line and Column are 1-based.
 type Token struct {
-	Raw  string
-	Keys []string
+	Raw        string
+	Keys       []string
+	Default    string
+	HasDefault bool
+	Filters    []string
+	Offset     int
+	Line       int
+	Column     int
+}
+
+// ApplyFilters runs value through the token's filters, in order.
+func (t *Token) ApplyFilters(value string) (string, error) {
+	for _, name := range t.Filters {
+		filter, ok := Filter(name)
+		if !ok {
+			return "", fmt.Errorf("unknown filter %q", name)
+		}
+		var err error
+		value, err = filter(value)
+		if err != nil {
+			return "", fmt.Errorf("filter %s: %v", name, err)
+		}
+	}
+	return value, nil
 }
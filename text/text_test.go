@@ -1,7 +1,10 @@
 package text_test
 
 import (
+	"bytes"
+	"errors"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,28 +28,46 @@ func TestText(t *testing.T) {
 	`)
 	expectedTokens := []*text.Token{
 		&text.Token{
-			Raw:  "{{MONGO_URL}}",
-			Keys: []string{"MONGO_URL"},
+			Raw:    "{{MONGO_URL}}",
+			Keys:   []string{"MONGO_URL"},
+			Offset: 19,
+			Line:   2,
+			Column: 9,
 		},
 		&text.Token{
-			Raw:  "{{ JWT_SECRET   }}",
-			Keys: []string{"JWT_SECRET"},
+			Raw:    "{{ JWT_SECRET   }}",
+			Keys:   []string{"JWT_SECRET"},
+			Offset: 53,
+			Line:   5,
+			Column: 12,
 		},
 		&text.Token{
-			Raw:  "{{ server.bind || SERVER_BIND}}",
-			Keys: []string{"server.bind", "SERVER_BIND"},
+			Raw:    "{{ server.bind || SERVER_BIND}}",
+			Keys:   []string{"server.bind", "SERVER_BIND"},
+			Offset: 93,
+			Line:   8,
+			Column: 10,
 		},
 		&text.Token{
-			Raw:  "{{server.port||SERVER_PORT}}",
-			Keys: []string{"server.port", "SERVER_PORT"},
+			Raw:    "{{server.port||SERVER_PORT}}",
+			Keys:   []string{"server.port", "SERVER_PORT"},
+			Offset: 135,
+			Line:   9,
+			Column: 10,
 		},
 		&text.Token{
-			Raw:  "{{   server.bind ||   SERVER_BIND   }}",
-			Keys: []string{"server.bind", "SERVER_BIND"},
+			Raw:    "{{   server.bind ||   SERVER_BIND   }}",
+			Keys:   []string{"server.bind", "SERVER_BIND"},
+			Offset: 191,
+			Line:   12,
+			Column: 10,
 		},
 		&text.Token{
-			Raw:  "{{server.por||SERVER_PORT}}",
-			Keys: []string{"server.por", "SERVER_PORT"},
+			Raw:    "{{server.por||SERVER_PORT}}",
+			Keys:   []string{"server.por", "SERVER_PORT"},
+			Offset: 240,
+			Line:   13,
+			Column: 10,
 		},
 	}
 	expectedData := []byte(`[database]
@@ -67,14 +88,17 @@ func TestText(t *testing.T) {
 	t.Run("nil data", func(t *testing.T) {
 		assert := assert.New(t)
 
-		assert.Nil(text.Tokens(nil))
+		tokens, err := text.Tokens(nil)
+		assert.Nil(tokens)
+		assert.Nil(err)
 		assert.Nil(text.Replace(nil, "k", "v"))
 	})
 
 	t.Run("valid execution", func(t *testing.T) {
 		assert := assert.New(t)
 
-		tokens := text.Tokens(data)
+		tokens, err := text.Tokens(data)
+		assert.Nil(err)
 		assert.NotNil(tokens)
 
 		assert.Equal(expectedTokens, tokens)
@@ -85,4 +109,369 @@ func TestText(t *testing.T) {
 		}
 		assert.Equal(expectedData, actual)
 	})
+
+	t.Run("render", func(t *testing.T) {
+		assert := assert.New(t)
+
+		tokens, err := text.Tokens(data)
+		assert.Nil(err)
+
+		var buf bytes.Buffer
+		index := 0
+		err = text.Render(&buf, data, tokens, func(token *text.Token) (string, error) {
+			value := strconv.Itoa(index)
+			index++
+			return value, nil
+		})
+		assert.Nil(err)
+		assert.Equal(expectedData, buf.Bytes())
+	})
+
+	t.Run("render does not expand values", func(t *testing.T) {
+		assert := assert.New(t)
+
+		data := []byte("{{A}} {{B}} {{A}}")
+		tokens, err := text.Tokens(data)
+		assert.Nil(err)
+
+		values := map[string]string{"A": "{{B}}", "B": "b"}
+		var buf bytes.Buffer
+		err = text.Render(&buf, data, tokens, func(token *text.Token) (string, error) {
+			return values[token.Keys[0]], nil
+		})
+		assert.Nil(err)
+		assert.Equal("{{B}} b {{B}}", buf.String())
+	})
+
+	t.Run("render errors", func(t *testing.T) {
+		assert := assert.New(t)
+
+		data := []byte("x {{A}}")
+		tokens, err := text.Tokens(data)
+		assert.Nil(err)
+
+		var buf bytes.Buffer
+		err = text.Render(&buf, data, tokens, func(token *text.Token) (string, error) {
+			return "", errors.New("it fails")
+		})
+		assert.EqualError(err, "it fails")
+
+		err = text.Render(&buf, []byte("x {{B}}"), tokens, func(token *text.Token) (string, error) {
+			return "", nil
+		})
+		assert.EqualError(err, "token {{A}} at 1:3 does not match the data")
+	})
+
+	t.Run("literal defaults", func(t *testing.T) {
+		assert := assert.New(t)
+
+		tokens, err := text.Tokens([]byte(`{{server.port || SERVER_PORT || "8080"}} {{ TIMEOUT | 2.5 }} {{"say \"hi\""}} {{OFFSET||-1}}`))
+		assert.Equal([]*text.Token{
+			&text.Token{
+				Raw:        `{{server.port || SERVER_PORT || "8080"}}`,
+				Keys:       []string{"server.port", "SERVER_PORT"},
+				Default:    "8080",
+				HasDefault: true,
+				Offset:     0,
+				Line:       1,
+				Column:     1,
+			},
+			&text.Token{
+				Raw:        "{{ TIMEOUT | 2.5 }}",
+				Keys:       []string{"TIMEOUT"},
+				Default:    "2.5",
+				HasDefault: true,
+				Offset:     41,
+				Line:       1,
+				Column:     42,
+			},
+			&text.Token{
+				Raw:        `{{"say \"hi\""}}`,
+				Keys:       []string{},
+				Default:    `say "hi"`,
+				HasDefault: true,
+				Offset:     61,
+				Line:       1,
+				Column:     62,
+			},
+			&text.Token{
+				Raw:        "{{OFFSET||-1}}",
+				Keys:       []string{"OFFSET"},
+				Default:    "-1",
+				HasDefault: true,
+				Offset:     78,
+				Line:       1,
+				Column:     79,
+			},
+		}, tokens)
+		assert.Nil(err)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		assert := assert.New(t)
+
+		tokens, err := text.Tokens([]byte(`{{DB_PASSWORD |> urlquery}} {{ name || "run" |> upper |> base64 }}`))
+		assert.Equal([]*text.Token{
+			&text.Token{
+				Raw:     "{{DB_PASSWORD |> urlquery}}",
+				Keys:    []string{"DB_PASSWORD"},
+				Filters: []string{"urlquery"},
+				Offset:  0,
+				Line:    1,
+				Column:  1,
+			},
+			&text.Token{
+				Raw:        `{{ name || "run" |> upper |> base64 }}`,
+				Keys:       []string{"name"},
+				Default:    "run",
+				HasDefault: true,
+				Filters:    []string{"upper", "base64"},
+				Offset:     28,
+				Line:       1,
+				Column:     29,
+			},
+		}, tokens)
+		assert.Nil(err)
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		assert := assert.New(t)
+
+		tokens, err := text.Tokens([]byte(`{{REDIS_DB_0 | servers[0].host | servers.0.host | config["my.key"] | 2fa.secret | 42}}`))
+		assert.Nil(err)
+		assert.Equal([]*text.Token{
+			&text.Token{
+				Raw:        `{{REDIS_DB_0 | servers[0].host | servers.0.host | config["my.key"] | 2fa.secret | 42}}`,
+				Keys:       []string{"REDIS_DB_0", "servers[0].host", "servers.0.host", `config["my.key"]`, "2fa.secret"},
+				Default:    "42",
+				HasDefault: true,
+				Offset:     0,
+				Line:       1,
+				Column:     1,
+			},
+		}, tokens)
+	})
+
+	t.Run("escapes", func(t *testing.T) {
+		assert := assert.New(t)
+
+		tokens, err := text.Tokens([]byte(`\{{ .Values.name }} {{"{{"}} .Values.name }} {{A}}`))
+		assert.Nil(err)
+		assert.Equal([]*text.Token{
+			&text.Token{
+				Raw:        `\{{`,
+				Keys:       []string{},
+				Default:    "{{",
+				HasDefault: true,
+				Offset:     0,
+				Line:       1,
+				Column:     1,
+			},
+			&text.Token{
+				Raw:        `{{"{{"}}`,
+				Keys:       []string{},
+				Default:    "{{",
+				HasDefault: true,
+				Offset:     20,
+				Line:       1,
+				Column:     21,
+			},
+			&text.Token{
+				Raw:    "{{A}}",
+				Keys:   []string{"A"},
+				Offset: 45,
+				Line:   1,
+				Column: 46,
+			},
+		}, tokens)
+	})
+
+	t.Run("escaped backslashes", func(t *testing.T) {
+		assert := assert.New(t)
+
+		tokens, err := text.Tokens([]byte(`\\{{A}} \\\{{ \ {{B}}`))
+		assert.Nil(err)
+		assert.Equal([]*text.Token{
+			&text.Token{
+				Raw:        `\\`,
+				Keys:       []string{},
+				Default:    `\`,
+				HasDefault: true,
+				Offset:     0,
+				Line:       1,
+				Column:     1,
+			},
+			&text.Token{
+				Raw:    "{{A}}",
+				Keys:   []string{"A"},
+				Offset: 2,
+				Line:   1,
+				Column: 3,
+			},
+			&text.Token{
+				Raw:        `\\\{{`,
+				Keys:       []string{},
+				Default:    `\{{`,
+				HasDefault: true,
+				Offset:     8,
+				Line:       1,
+				Column:     9,
+			},
+			&text.Token{
+				Raw:    "{{B}}",
+				Keys:   []string{"B"},
+				Offset: 16,
+				Line:   1,
+				Column: 17,
+			},
+		}, tokens)
+	})
+
+	t.Run("delimiters", func(t *testing.T) {
+		assert := assert.New(t)
+
+		delims, err := text.ParseDelims("<% %>")
+		assert.Nil(err)
+		assert.Equal(text.Delims{Left: "<%", Right: "%>"}, delims)
+
+		tokens, err := delims.Tokens([]byte(`{{ .Values.name }} <% A || "x" |> upper %> \<%`))
+		assert.Nil(err)
+		assert.Equal([]*text.Token{
+			&text.Token{
+				Raw:        `<% A || "x" |> upper %>`,
+				Keys:       []string{"A"},
+				Default:    "x",
+				HasDefault: true,
+				Filters:    []string{"upper"},
+				Offset:     19,
+				Line:       1,
+				Column:     20,
+			},
+			&text.Token{
+				Raw:        `\<%`,
+				Keys:       []string{},
+				Default:    "<%",
+				HasDefault: true,
+				Offset:     43,
+				Line:       1,
+				Column:     44,
+			},
+		}, tokens)
+
+		_, err = delims.Tokens([]byte(`<% A B %>`))
+		assert.EqualError(err, "1:6: expected | or %> but found 'B'")
+
+		for _, invalid := range []string{"", "{{", "{{ }} ]]", "[[ [[", "<| |>", `" "`} {
+			_, err := text.ParseDelims(invalid)
+			assert.Error(err, invalid)
+		}
+	})
+
+	t.Run("syntax errors", func(t *testing.T) {
+		cases := map[string]*text.SyntaxError{
+			"abc {{ A":           {Msg: "unterminated token", Offset: 4, Line: 1, Column: 5},
+			"abc\n{{ A\n}}":      {Msg: "unterminated token", Offset: 4, Line: 2, Column: 1},
+			"{{ A {{ B }} }}":    {Msg: "nested token", Offset: 5, Line: 1, Column: 6},
+			"{{}}":               {Msg: "empty key", Offset: 2, Line: 1, Column: 3},
+			"{{ A || }}":         {Msg: "empty key", Offset: 8, Line: 1, Column: 9},
+			"{{ | A }}":          {Msg: "empty key", Offset: 3, Line: 1, Column: 4},
+			"{{ |> upper }}":     {Msg: "empty key", Offset: 3, Line: 1, Column: 4},
+			"x\n  {{ + }}":       {Msg: "invalid character '+'", Offset: 7, Line: 2, Column: 6},
+			"{{ A B }}":          {Msg: "expected | or }} but found 'B'", Offset: 5, Line: 1, Column: 6},
+			`{{"8080" || PORT}}`: {Msg: "default must be the last alternative", Offset: 12, Line: 1, Column: 13},
+			`{{PORT || "8080}}`:  {Msg: "unterminated string", Offset: 10, Line: 1, Column: 11},
+			`{{PORT || "\q"}}`:   {Msg: `invalid string "\q"`, Offset: 10, Line: 1, Column: 11},
+			`{{PORT || 1.2.3}}`:  {Msg: `invalid number "1.2.3"`, Offset: 10, Line: 1, Column: 11},
+			"{{A |> }}":          {Msg: "missing filter name", Offset: 7, Line: 1, Column: 8},
+			"{{A |> upper B}}":   {Msg: "expected | or }} but found 'B'", Offset: 13, Line: 1, Column: 14},
+			"{{A |> upper | B}}": {Msg: "unexpected | after filters", Offset: 13, Line: 1, Column: 14},
+			"{{ servers[a] }}":   {Msg: "invalid key segment", Offset: 10, Line: 1, Column: 11},
+			"{{ servers[0 }}":    {Msg: "unterminated key segment", Offset: 10, Line: 1, Column: 11},
+			`{{ config["a }}`:    {Msg: "unterminated string", Offset: 10, Line: 1, Column: 11},
+			"{{A |> *}}":         {Msg: "invalid character '*' in filter name", Offset: 7, Line: 1, Column: 8},
+		}
+
+		for data, expected := range cases {
+			t.Run(data, func(t *testing.T) {
+				assert := assert.New(t)
+
+				tokens, err := text.Tokens([]byte(data))
+				assert.Nil(tokens)
+				assert.Equal(expected, err)
+			})
+		}
+	})
+
+	t.Run("stray closing delimiter", func(t *testing.T) {
+		assert := assert.New(t)
+
+		tokens, err := text.Tokens([]byte(`{"a":{"b":{{A}}}}`))
+		assert.Nil(err)
+		assert.Equal([]*text.Token{
+			&text.Token{
+				Raw:    "{{A}}",
+				Keys:   []string{"A"},
+				Offset: 10,
+				Line:   1,
+				Column: 11,
+			},
+		}, tokens)
+	})
+
+	t.Run("apply filters", func(t *testing.T) {
+		cases := []struct {
+			filters  []string
+			value    string
+			expected string
+		}{
+			{[]string{"upper"}, "It Works", "IT WORKS"},
+			{[]string{"lower"}, "It Works", "it works"},
+			{[]string{"trim"}, " \tit works\n", "it works"},
+			{[]string{"base64"}, "it works", "aXQgd29ya3M="},
+			{[]string{"base64decode"}, "aXQgd29ya3M=", "it works"},
+			{[]string{"json"}, `say "<hi>"`, `"say \"<hi>\""`},
+			{[]string{"urlquery"}, "p@ss word", "p%40ss+word"},
+			{[]string{"sha256"}, "it works", "f06058665bb4627a2ab017d6625ff0a3487d583cd91ed650037ef11c2cb8defb"},
+			{[]string{"trim", "upper", "base64"}, " it works ", "SVQgV09SS1M="},
+			{[]string{"yaml"}, `{"b":{"enabled":true,"ratio":0.5},"a":["x",1,null]}`, "b:\n  enabled: true\n  ratio: 0.5\na:\n- x\n- 1\n- null"},
+			{[]string{"yaml"}, "it: works", `'it: works'`},
+		}
+
+		for _, c := range cases {
+			t.Run(strings.Join(c.filters, ","), func(t *testing.T) {
+				assert := assert.New(t)
+
+				token := &text.Token{Filters: c.filters}
+				actual, err := token.ApplyFilters(c.value)
+				assert.Nil(err)
+				assert.Equal(c.expected, actual)
+			})
+		}
+
+		t.Run("errors", func(t *testing.T) {
+			assert := assert.New(t)
+
+			_, err := (&text.Token{Filters: []string{"nope"}}).ApplyFilters("x")
+			assert.EqualError(err, `unknown filter "nope"`)
+
+			_, err = (&text.Token{Filters: []string{"base64decode"}}).ApplyFilters("!")
+			assert.EqualError(err, "filter base64decode: illegal base64 data at input byte 0")
+		})
+	})
+
+	t.Run("register filter", func(t *testing.T) {
+		assert := assert.New(t)
+
+		text.RegisterFilter("reverse", func(value string) (string, error) {
+			out := []byte(value)
+			for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+				out[i], out[j] = out[j], out[i]
+			}
+			return string(out), nil
+		})
+
+		actual, err := (&text.Token{Filters: []string{"reverse"}}).ApplyFilters("abc")
+		assert.Nil(err)
+		assert.Equal("cba", actual)
+	})
 }
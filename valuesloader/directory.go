@@ -0,0 +1,99 @@
+package valuesloader
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DirectoryLoader reads the files in dir, like the Docker secrets in
+// /run/secrets or a Kubernetes secret volume, and returns a loader for their
+// contents. A key is the name of a file in dir, or a path in its
+// subdirectories with the names separated by dots, so database.password is
+// read from database/password when there is no database.password file. The
+// entries starting with .., like the ..data link of Kubernetes, are skipped.
+// When trimNewline is true, a trailing newline is removed from the values.
+func DirectoryLoader(ctx context.Context, dir string, trimNewline bool) (ValueLoaderFunc, error) {
+	values := map[string]string{}
+	err := wait(ctx, func() error {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return readSecrets(ctx, dir, nil, []os.FileInfo{info}, values)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if trimNewline {
+		for key, value := range values {
+			if strings.HasSuffix(value, "\n") {
+				values[key] = strings.TrimSuffix(value[:len(value)-1], "\r")
+			}
+		}
+	}
+	return mapLoader(values), nil
+}
+
+// readSecrets adds the files in dir to values, with the names in path as the
+// prefix of their keys. parents are dir and the directories containing it, so
+// a link to one of them is not followed again.
+func readSecrets(ctx context.Context, dir string, path []string, parents []os.FileInfo, values map[string]string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), "..") {
+			continue
+		}
+
+		name := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		entryPath := append(path[:len(path):len(path)], entry.Name())
+
+		if info.IsDir() {
+			if isParent(info, parents) {
+				continue
+			}
+			if err := readSecrets(ctx, name, entryPath, append(parents, info), values); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		key := strings.Join(entryPath, ".")
+		if _, ok := values[key]; !ok || len(entryPath) == 1 {
+			values[key] = string(data)
+		}
+	}
+	return nil
+}
+
+// isParent reports whether info is the same directory as one of parents.
+func isParent(info os.FileInfo, parents []os.FileInfo) bool {
+	for _, parent := range parents {
+		if os.SameFile(info, parent) {
+			return true
+		}
+	}
+	return false
+}
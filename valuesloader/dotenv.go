@@ -0,0 +1,89 @@
+package valuesloader
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// DotenvFileLoader reads the dotenv file at filepath and returns a loader for
+// its variables, by name. When expand is true, references like ${NAME} in
+// unquoted and double quoted values are replaced by the variables defined
+// earlier in the same file, otherwise the values are used as written.
+func DotenvFileLoader(ctx context.Context, filepath string, expand bool) (ValueLoaderFunc, error) {
+	data, err := readFile(ctx, filepath)
+	if err != nil {
+		return nil, err
+	}
+	if !expand {
+		data = escapeDollars(data)
+	}
+
+	values, err := godotenv.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return mapLoader(values), nil
+}
+
+// escapeDollars escapes every $ in the values of a dotenv file that godotenv
+// would expand, so they are kept as written.
+func escapeDollars(data []byte) []byte {
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 || trimmed[0] == '#' {
+			continue
+		}
+
+		sep, singleQuoted := dotenvValue(string(line))
+		if sep == -1 || singleQuoted {
+			continue
+		}
+		escaped := bytes.Replace(line[sep+1:], []byte("$"), []byte(`\$`), -1)
+		lines[i] = append(line[:sep+1:sep+1], escaped...)
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// dotenvValue returns the position of the separator between the key and the
+// value of line, or -1 if there is none, and whether the value is single
+// quoted, as seen by godotenv v1.3.0: the comments are removed first, the
+// separator is the first = or the first : if it comes before, and the value is
+// single quoted only if the whole of it is between single quotes.
+func dotenvValue(line string) (sep int, singleQuoted bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if strings.Contains(line, "#") {
+		segments := strings.Split(line, "#")
+		quotesAreOpen := false
+		kept := []string{}
+		for _, segment := range segments {
+			if strings.Count(segment, "\"") == 1 || strings.Count(segment, "'") == 1 {
+				if quotesAreOpen {
+					quotesAreOpen = false
+					kept = append(kept, segment)
+				} else {
+					quotesAreOpen = true
+				}
+			}
+			if len(kept) == 0 || quotesAreOpen {
+				kept = append(kept, segment)
+			}
+		}
+		line = strings.Join(kept, "#")
+	}
+
+	sep = strings.Index(line, "=")
+	if colon := strings.Index(line, ":"); colon != -1 && (colon < sep || sep == -1) {
+		sep = colon
+	}
+	if sep == -1 {
+		return -1, false
+	}
+
+	value := strings.Trim(line[sep+1:], " ")
+	return sep, len(value) > 1 && value[0] == '\'' && value[len(value)-1] == '\''
+}
@@ -0,0 +1,43 @@
+package valuesloader
+
+import (
+	"context"
+	"io/ioutil"
+)
+
+// readFile reads the file at path like ioutil.ReadFile, but returns the error
+// of ctx as soon as it is done, so a read blocked on a FIFO or a hung network
+// mount can be interrupted.
+func readFile(ctx context.Context, path string) ([]byte, error) {
+	var data []byte
+	err := wait(ctx, func() error {
+		var err error
+		data, err = ioutil.ReadFile(path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// wait runs fn and returns its error, or the error of ctx if it is done first.
+// A blocked file operation cannot be stopped, so fn keeps running in the
+// background until it returns and its results must not be used then.
+func wait(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
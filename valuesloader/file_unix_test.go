@@ -0,0 +1,38 @@
+//go:build !windows
+// +build !windows
+
+package valuesloader_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txgruppi/run/valuesloader"
+)
+
+func TestFileLoaderInterrupted(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "run-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fifo := filepath.Join(dir, "values.json")
+	require.Nil(t, syscall.Mkfifo(fifo, 0600))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	loader, err := valuesloader.JSONFileLoader(ctx, fifo)
+	require.Nil(t, loader)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// Unblock the read left in the background.
+	writer, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	require.Nil(t, err)
+	require.Nil(t, writer.Close())
+}
@@ -0,0 +1,74 @@
+package valuesloader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// INIFileLoader reads the INI file at filepath and returns a loader for its
+// values. Keys in a section are looked up as section.key and the keys before
+// the first section by their name.
+func INIFileLoader(ctx context.Context, filepath string) (ValueLoaderFunc, error) {
+	data, err := readFile(ctx, filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := parseINI(filepath, string(data))
+	if err != nil {
+		return nil, err
+	}
+	return mapLoader(values), nil
+}
+
+// parseINI parses an INI document. Lines starting with ; or # are comments,
+// keys and values are separated by = or : and the values are trimmed, with
+// the quotes around them removed. The last value of a repeated key wins. name
+// is used in the errors.
+func parseINI(name, data string) (map[string]string, error) {
+	values := map[string]string{}
+	section := ""
+
+	data = strings.TrimPrefix(data, "\ufeff")
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("%s:%d: unterminated section %q", name, i+1, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" {
+				return nil, fmt.Errorf("%s:%d: empty section name", name, i+1)
+			}
+			continue
+		}
+
+		sep := strings.IndexAny(line, "=:")
+		if sep == -1 {
+			return nil, fmt.Errorf("%s:%d: expected key = value, got %q", name, i+1, line)
+		}
+		key := strings.TrimSpace(line[:sep])
+		if key == "" {
+			return nil, fmt.Errorf("%s:%d: empty key", name, i+1)
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		values[key] = unquoteINI(strings.TrimSpace(line[sep+1:]))
+	}
+
+	return values, nil
+}
+
+// unquoteINI removes the double or single quotes around value, if any.
+func unquoteINI(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
@@ -1,34 +1,57 @@
 package valuesloader
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"strconv"
-	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/valyala/fastjson"
 )
 
-func EnvironmentLoader() (ValueLoaderFunc, error) {
+// EnvironmentLoader returns a loader for the environment variables of the
+// current process.
+func EnvironmentLoader(ctx context.Context) (ValueLoaderFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return func(key string) (string, bool) {
 		return os.LookupEnv(key)
 	}, nil
 }
 
-func JSONLoader(data []byte) (ValueLoaderFunc, error) {
+// mapLoader returns a loader for the values, by key.
+func mapLoader(values map[string]string) ValueLoaderFunc {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
+
+// JSONLoader returns a loader for a JSON document.
+func JSONLoader(ctx context.Context, data []byte) (ValueLoaderFunc, error) {
+	return jsonLoader(ctx, data, false)
+}
+
+// jsonLoader returns a loader for a JSON document. When decodeStrings is true
+// the escapes in the strings are decoded, otherwise the strings are returned as
+// written in the document, without the quotes.
+func jsonLoader(ctx context.Context, data []byte, decodeStrings bool) (ValueLoaderFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	parsed, err := fastjson.ParseBytes(data)
 	if err != nil {
 		return nil, err
 	}
 	return func(key string) (string, bool) {
-		keys := strings.Split(key, ".")
-		if !parsed.Exists(keys...) {
+		keys, err := splitKey(key)
+		if err != nil || !parsed.Exists(keys...) {
 			return "", false
 		}
 
@@ -44,6 +67,13 @@ func JSONLoader(data []byte) (ValueLoaderFunc, error) {
 			return "false", true
 
 		case fastjson.TypeString:
+			if decodeStrings {
+				str, err := value.StringBytes()
+				if err != nil {
+					return "", false
+				}
+				return string(str), true
+			}
 			str := value.String()
 			return str[1 : len(str)-1], true
 
@@ -56,46 +86,105 @@ func JSONLoader(data []byte) (ValueLoaderFunc, error) {
 			}
 			return "", false
 
+		case fastjson.TypeObject, fastjson.TypeArray:
+			return value.String(), true
+
 		default:
 			return "", false
 		}
 	}, nil
 }
 
-func RemoteJSONLoader(url string) (ValueLoaderFunc, error) {
-	res, err := http.Get(url)
+// RemoteJSONLoader fetches the JSON document at url and returns a JSONLoader
+// for it. The request is made as described in fetch.
+func RemoteJSONLoader(ctx context.Context, url string, opts RemoteOptions) (ValueLoaderFunc, error) {
+	data, err := fetch(ctx, url, opts)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
+	return JSONLoader(ctx, data)
+}
 
-	data, err := ioutil.ReadAll(res.Body)
+// YAMLLoader returns a loader for a YAML document. Keys are resolved and the
+// values formatted exactly like JSONLoader does.
+func YAMLLoader(ctx context.Context, data []byte) (ValueLoaderFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	converted, err := yamlToJSON(data)
 	if err != nil {
 		return nil, err
 	}
+	return jsonLoader(ctx, converted, true)
+}
 
-	return JSONLoader(data)
+// YAMLFileLoader reads the YAML file at filepath and returns a YAMLLoader for
+// it.
+func YAMLFileLoader(ctx context.Context, filepath string) (ValueLoaderFunc, error) {
+	data, err := readFile(ctx, filepath)
+	if err != nil {
+		return nil, err
+	}
+	return YAMLLoader(ctx, data)
 }
 
-func JSONFileLoader(filepath string) (ValueLoaderFunc, error) {
-	file, err := os.Open(filepath)
+// RemoteYAMLLoader fetches the YAML document at url and returns a YAMLLoader
+// for it. The request is made as described in fetch.
+func RemoteYAMLLoader(ctx context.Context, url string, opts RemoteOptions) (ValueLoaderFunc, error) {
+	data, err := fetch(ctx, url, opts)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	return YAMLLoader(ctx, data)
+}
+
+// TOMLLoader returns a loader for a TOML document. Keys are resolved and the
+// values formatted exactly like JSONLoader does, tables are objects and arrays
+// of tables are arrays.
+func TOMLLoader(ctx context.Context, data []byte) (ValueLoaderFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	converted, err := tomlToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return jsonLoader(ctx, converted, true)
+}
+
+// TOMLFileLoader reads the TOML file at filepath and returns a TOMLLoader for
+// it.
+func TOMLFileLoader(ctx context.Context, filepath string) (ValueLoaderFunc, error) {
+	data, err := readFile(ctx, filepath)
+	if err != nil {
+		return nil, err
+	}
+	return TOMLLoader(ctx, data)
+}
 
-	data, err := ioutil.ReadAll(file)
+func JSONFileLoader(ctx context.Context, filepath string) (ValueLoaderFunc, error) {
+	data, err := readFile(ctx, filepath)
 	if err != nil {
 		return nil, err
 	}
 
-	return JSONLoader(data)
+	return JSONLoader(ctx, data)
 }
 
-func AWSSecretsManagerLoader(secretArn string) (ValueLoaderFunc, error) {
+// AWSSecretsManagerLoader fetches the secret with a JSON encoded value
+// identified by secretArn and returns a JSONLoader for it. Failed requests are
+// retried as set by opts.
+func AWSSecretsManagerLoader(ctx context.Context, secretArn string, opts RemoteOptions) (ValueLoaderFunc, error) {
+	client, err := opts.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
 	sess, err := session.NewSessionWithOptions(session.Options{
 		Config: aws.Config{
 			Credentials: credentials.NewEnvCredentials(),
+			HTTPClient:  client,
+			MaxRetries:  aws.Int(0),
 		},
 	})
 	if err != nil {
@@ -103,8 +192,16 @@ func AWSSecretsManagerLoader(secretArn string) (ValueLoaderFunc, error) {
 	}
 
 	sm := secretsmanager.New(sess)
-	out, err := sm.GetSecretValue(&secretsmanager.GetSecretValueInput{
-		SecretId: aws.String(secretArn),
+	var out *secretsmanager.GetSecretValueOutput
+	err = retry(ctx, opts, func(ctx context.Context) error {
+		var err error
+		out, err = sm.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secretArn),
+		})
+		if err != nil && !request.IsErrorRetryable(err) && !request.IsErrorThrottle(err) {
+			return &permanentError{err}
+		}
+		return err
 	})
 	if err != nil {
 		return nil, err
@@ -113,5 +210,5 @@ func AWSSecretsManagerLoader(secretArn string) (ValueLoaderFunc, error) {
 		return nil, fmt.Errorf("got unexpected nil value")
 	}
 
-	return JSONLoader([]byte(*out.SecretString))
+	return JSONLoader(ctx, []byte(*out.SecretString))
 }
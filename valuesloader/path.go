@@ -0,0 +1,83 @@
+package valuesloader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// splitKey splits a key into the segments used to walk a document. Segments
+// are separated by dots and may also be written as index segments, like
+// servers[0], or quoted segments, like config["some.key"], for names that
+// contain dots or spaces.
+func splitKey(key string) ([]string, error) {
+	segments := []string{}
+	var current strings.Builder
+	afterBracket := false
+
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '.':
+			if !afterBracket {
+				segments = append(segments, current.String())
+				current.Reset()
+			}
+			afterBracket = false
+
+		case '[':
+			if current.Len() > 0 {
+				segments = append(segments, current.String())
+				current.Reset()
+			}
+			end := strings.IndexByte(key[i:], ']')
+			if i+1 < len(key) && key[i+1] == '"' {
+				end = closingQuote(key, i+1)
+				if end == -1 || end+1 >= len(key) || key[end+1] != ']' {
+					return nil, fmt.Errorf("invalid key segment at %d in %q", i, key)
+				}
+				segment, err := strconv.Unquote(key[i+1 : end+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid key segment at %d in %q", i, key)
+				}
+				segments = append(segments, segment)
+				i = end + 1
+			} else {
+				if end == -1 {
+					return nil, fmt.Errorf("invalid key segment at %d in %q", i, key)
+				}
+				segment := key[i+1 : i+end]
+				if _, err := strconv.ParseUint(segment, 10, 64); err != nil {
+					return nil, fmt.Errorf("invalid key segment at %d in %q", i, key)
+				}
+				segments = append(segments, segment)
+				i += end
+			}
+			afterBracket = true
+
+		default:
+			if afterBracket {
+				return nil, fmt.Errorf("unexpected %q at %d in %q", key[i], i, key)
+			}
+			current.WriteByte(key[i])
+		}
+	}
+
+	if !afterBracket {
+		segments = append(segments, current.String())
+	}
+	return segments, nil
+}
+
+// closingQuote returns the index of the double quote closing the string that
+// starts at i or -1 if it is not terminated.
+func closingQuote(key string, i int) int {
+	for j := i + 1; j < len(key); j++ {
+		switch key[j] {
+		case '\\':
+			j++
+		case '"':
+			return j
+		}
+	}
+	return -1
+}
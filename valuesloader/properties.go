@@ -0,0 +1,152 @@
+package valuesloader
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// PropertiesFileLoader reads the Java properties file at filepath and returns a
+// loader for its values. Keys are looked up as written in the file, after
+// their escapes are resolved. The file is read as UTF-8.
+func PropertiesFileLoader(ctx context.Context, filepath string) (ValueLoaderFunc, error) {
+	data, err := readFile(ctx, filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := parseProperties(filepath, string(data))
+	if err != nil {
+		return nil, err
+	}
+	return mapLoader(values), nil
+}
+
+// parseProperties parses a Java properties document as described in
+// java.util.Properties.load: lines starting with # or ! are comments, a line
+// ending with an odd number of backslashes continues in the next one, and the
+// key ends at the first unescaped =, : or whitespace. name is used in the
+// errors.
+func parseProperties(name, data string) (map[string]string, error) {
+	values := map[string]string{}
+
+	data = strings.TrimPrefix(data, "\ufeff")
+	data = strings.Replace(data, "\r\n", "\n", -1)
+	lines := strings.Split(strings.Replace(data, "\r", "\n", -1), "\n")
+	for i := 0; i < len(lines); {
+		number := i + 1
+		line := strings.TrimLeft(lines[i], " \t\f")
+		i++
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		for continues(line) {
+			line = line[:len(line)-1]
+			if i == len(lines) {
+				break
+			}
+			line += strings.TrimLeft(lines[i], " \t\f")
+			i++
+		}
+
+		keyEnd := len(line)
+		for j := 0; j < len(line); j++ {
+			if line[j] == '\\' {
+				j++
+				continue
+			}
+			if strings.IndexByte("=: \t\f", line[j]) != -1 {
+				keyEnd = j
+				break
+			}
+		}
+		rest := strings.TrimLeft(line[keyEnd:], " \t\f")
+		if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+			rest = strings.TrimLeft(rest[1:], " \t\f")
+		}
+
+		key, err := unescapeProperty(line[:keyEnd])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, number, err)
+		}
+		value, err := unescapeProperty(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, number, err)
+		}
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+// continues reports whether line ends with an odd number of backslashes.
+func continues(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// unescapeProperty resolves the escapes of a key or value of a properties
+// file. \t, \n, \r and \f are the control characters, \uXXXX is an UTF-16 code
+// unit, with surrogate pairs combined, and a backslash followed by any other
+// character is that character.
+func unescapeProperty(s string) (string, error) {
+	if strings.IndexByte(s, '\\') == -1 {
+		return s, nil
+	}
+
+	runes := make([]rune, 0, len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r != '\\' || i == len(s) {
+			runes = append(runes, r)
+			continue
+		}
+
+		r, size = utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch r {
+		case 't':
+			r = '\t'
+		case 'n':
+			r = '\n'
+		case 'r':
+			r = '\r'
+		case 'f':
+			r = '\f'
+		case 'u':
+			if i+4 > len(s) {
+				return "", fmt.Errorf("malformed \\uxxxx encoding in %q", s)
+			}
+			unit, err := strconv.ParseUint(s[i:i+4], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\uxxxx encoding in %q", s)
+			}
+			i += 4
+			r = rune(unit)
+		}
+		runes = append(runes, r)
+	}
+
+	for i := 0; i < len(runes); i++ {
+		if !utf16.IsSurrogate(runes[i]) {
+			continue
+		}
+		if i+1 < len(runes) {
+			if r := utf16.DecodeRune(runes[i], runes[i+1]); r != utf8.RuneError {
+				runes = append(runes[:i+1], runes[i+2:]...)
+				runes[i] = r
+				continue
+			}
+		}
+		runes[i] = utf8.RuneError
+	}
+
+	return string(runes), nil
+}
@@ -0,0 +1,285 @@
+package valuesloader
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RemoteOptions are the settings used by the loaders that fetch the values
+// from a remote service.
+type RemoteOptions struct {
+	// ConnectTimeout is the maximum time to open a connection, including the
+	// TLS handshake. Zero means no timeout.
+	ConnectTimeout time.Duration
+
+	// ReadTimeout is the maximum time to wait for data from an open
+	// connection. Zero means no timeout.
+	ReadTimeout time.Duration
+
+	// Retries is the number of times a failed request is retried.
+	Retries int
+
+	// Backoff is the delay before the first retry. It doubles after every
+	// retry, up to MaxBackoff, and a random jitter of up to half of it is
+	// removed from every delay.
+	Backoff time.Duration
+
+	// MaxBackoff is the longest delay between retries.
+	MaxBackoff time.Duration
+
+	// Header is sent with the requests of RemoteJSONLoader and
+	// RemoteYAMLLoader.
+	Header http.Header
+
+	// CAFile is a PEM file with the certificates of the authorities trusted
+	// in addition to the ones of the system.
+	CAFile string
+
+	// CertFile and KeyFile are the PEM files with the client certificate and
+	// its key, used to authenticate to servers that require it.
+	CertFile string
+	KeyFile  string
+
+	// Proxy is the URL of the proxy used for every request. When it is empty
+	// the proxy is read from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables.
+	Proxy string
+}
+
+// DefaultRemoteOptions are the RemoteOptions used by the command line.
+var DefaultRemoteOptions = RemoteOptions{
+	ConnectTimeout: 10 * time.Second,
+	ReadTimeout:    30 * time.Second,
+	Retries:        3,
+	Backoff:        500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+}
+
+// httpClient returns an http.Client with the timeouts, certificates and
+// proxy in opts.
+func (opts RemoteOptions) httpClient() (*http.Client, error) {
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		u, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSHandshakeTimeout = opts.ConnectTimeout
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil || opts.ReadTimeout <= 0 {
+			return conn, err
+		}
+		return &timeoutConn{Conn: conn, timeout: opts.ReadTimeout}, nil
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// tlsConfig returns the TLS configuration with the certificates in opts or
+// nil if there are none.
+func (opts RemoteOptions) tlsConfig() (*tls.Config, error) {
+	if opts.CAFile == "" && opts.CertFile == "" && opts.KeyFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{}
+
+	if opts.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, errors.New("a client certificate requires both the certificate and the key files")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// fetch returns the body of a GET request to rawurl. The headers in opts are
+// sent with the request and the user and password in rawurl, if any, are sent
+// with basic authentication. Responses with a non-2xx status are errors.
+// Failed requests are retried as set by opts.
+func fetch(ctx context.Context, rawurl string, opts RemoteOptions) ([]byte, error) {
+	client, err := opts.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	err = retry(ctx, opts, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawurl, nil)
+		if err != nil {
+			return &permanentError{err}
+		}
+		for name, values := range opts.Header {
+			req.Header[name] = values
+		}
+		if user := req.URL.User; user != nil && req.Header.Get("Authorization") == "" {
+			password, _ := user.Password()
+			req.SetBasicAuth(user.Username(), password)
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		if err := checkStatus(res); err != nil {
+			return err
+		}
+		data, err = ioutil.ReadAll(res.Body)
+		return err
+	})
+	return data, err
+}
+
+// statusError is returned for a response with a non-2xx status.
+type statusError struct {
+	url    string
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %s from %s", e.status, e.url)
+}
+
+// checkStatus returns nil for a 2xx response. Server errors and 429 Too Many
+// Requests are returned as is, so they are retried, any other status returns
+// a permanentError.
+func checkStatus(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return nil
+	}
+	err := &statusError{url: redact(res.Request.URL), status: res.Status}
+	if res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+	return &permanentError{err}
+}
+
+// RedactURL returns rawurl with the password, if any, replaced by xxxxx, so it
+// can be logged. A value that is not a valid URL is replaced as a whole.
+func RedactURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "xxxxx"
+	}
+	return redact(u)
+}
+
+// redact returns u without the password.
+func redact(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	c := *u
+	if _, ok := u.User.Password(); ok {
+		c.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return c.String()
+}
+
+// timeoutConn is a connection that fails any read that gets no data within
+// timeout.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// permanentError is an error that must not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// retry calls fn until it succeeds, it returns a permanentError or it has been
+// retried opts.Retries times, waiting between the calls as set by opts. It
+// returns the last error of fn or the error of ctx if it is done first.
+func retry(ctx context.Context, opts RemoteOptions, fn func(ctx context.Context) error) error {
+	delay := opts.Backoff
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if attempt >= opts.Retries {
+			return err
+		}
+
+		wait := delay
+		if wait > 0 {
+			wait -= time.Duration(rand.Int63n(int64(wait)/2 + 1))
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if delay *= 2; opts.MaxBackoff > 0 && delay > opts.MaxBackoff {
+			delay = opts.MaxBackoff
+		}
+	}
+}
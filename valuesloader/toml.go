@@ -0,0 +1,80 @@
+package valuesloader
+
+import (
+	"sort"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// tomlToJSON converts a TOML document to JSON, keeping the order of the keys
+// of the tables. Datetimes become strings formatted as in TOML.
+func tomlToJSON(data []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	md, err := toml.Decode(string(data), &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	order := map[string]int{}
+	for i, key := range md.Keys() {
+		if _, ok := order[key.String()]; !ok {
+			order[key.String()] = i
+		}
+	}
+	return appendJSON(nil, tomlValue(doc, nil, order))
+}
+
+// tomlValue converts a decoded TOML value at path to the values handled by
+// appendJSON. order is the position of every key in the document.
+func tomlValue(value interface{}, path toml.Key, order map[string]int) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		slice := yaml.MapSlice{}
+		positions := map[string]int{}
+		for key, item := range v {
+			keyPath := append(path[:len(path):len(path)], key)
+			if i, ok := order[keyPath.String()]; ok {
+				positions[key] = i
+			} else {
+				positions[key] = len(order)
+			}
+			slice = append(slice, yaml.MapItem{Key: key, Value: tomlValue(item, keyPath, order)})
+		}
+		sort.Slice(slice, func(i, j int) bool {
+			a, b := slice[i].Key.(string), slice[j].Key.(string)
+			if positions[a] != positions[b] {
+				return positions[a] < positions[b]
+			}
+			return a < b
+		})
+		return slice
+
+	case []map[string]interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = tomlValue(item, path, order)
+		}
+		return items
+
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = tomlValue(item, path, order)
+		}
+		return items
+
+	case time.Time:
+		switch v.Location().String() {
+		case "datetime-local":
+			return v.Format("2006-01-02T15:04:05.999999999")
+		case "date-local":
+			return v.Format("2006-01-02")
+		case "time-local":
+			return v.Format("15:04:05.999999999")
+		}
+		return v.Format(time.RFC3339Nano)
+	}
+	return value
+}
@@ -0,0 +1,124 @@
+package valuesloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// yamlToJSON converts a YAML document to JSON, keeping the order of the keys
+// of the mappings. Keys that are not strings are formatted as strings, and
+// timestamps and the floats with no JSON representation, like .inf, become
+// strings as well.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc yamlValue
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.value == nil {
+		return appendJSON(nil, yaml.MapSlice{})
+	}
+	return appendJSON(nil, doc.value)
+}
+
+// yamlValue decodes any YAML value with its mappings as yaml.MapSlice, so the
+// order of their keys is kept at any depth, inside sequences as well.
+type yamlValue struct {
+	value interface{}
+}
+
+func (v *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// A sequence of mappings can be decoded as a yaml.MapSlice, which is a
+	// slice too, so sequences are tried first.
+	var sequence []yamlValue
+	if err := unmarshal(&sequence); err == nil {
+		items := make([]interface{}, len(sequence))
+		for i, item := range sequence {
+			items[i] = item.value
+		}
+		v.value = items
+		return nil
+	}
+
+	var mapping yaml.MapSlice
+	if err := unmarshal(&mapping); err == nil {
+		v.value = mapping
+		return nil
+	}
+
+	return unmarshal(&v.value)
+}
+
+func appendJSON(buf []byte, value interface{}) ([]byte, error) {
+	var err error
+	switch v := value.(type) {
+	case nil:
+		return append(buf, "null"...), nil
+
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+
+	case uint64:
+		return strconv.AppendUint(buf, v, 10), nil
+
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return appendJSONString(buf, strconv.FormatFloat(v, 'f', -1, 64)), nil
+		}
+		return strconv.AppendFloat(buf, v, 'f', -1, 64), nil
+
+	case string:
+		return appendJSONString(buf, v), nil
+
+	case time.Time:
+		return appendJSONString(buf, v.Format(time.RFC3339Nano)), nil
+
+	case yaml.MapSlice:
+		buf = append(buf, '{')
+		for i, item := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, fmt.Sprint(item.Key))
+			buf = append(buf, ':')
+			if buf, err = appendJSON(buf, item.Value); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, '}'), nil
+
+	case []interface{}:
+		buf = append(buf, '[')
+		for i, item := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			if buf, err = appendJSON(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, ']'), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported YAML value of type %T", value)
+	}
+}
+
+func appendJSONString(buf []byte, s string) []byte {
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return append(buf, bytes.TrimSuffix(out.Bytes(), []byte("\n"))...)
+}